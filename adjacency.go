@@ -0,0 +1,217 @@
+package bohm
+
+import (
+	"strconv"
+	"strings"
+
+	"vallon.me/bohm/config"
+)
+
+// tileEdges holds the outermost pixel strips of an oriented tile.
+type tileEdges struct {
+	top, right, bottom, left []byte
+}
+
+func (tm *Tiled) edges(t int) (tileEdges, error) {
+	texture, err := tm.texture(tm.tiles[t])
+	if err != nil {
+		return tileEdges{}, err
+	}
+
+	S := tm.tileSize
+	pix := texture.CarTile(tm.tiles[t].cardinality)
+
+	e := tileEdges{
+		top:    pix[:4*S],
+		bottom: pix[4*S*(S-1):],
+		right:  make([]byte, 0, 4*S),
+		left:   make([]byte, 0, 4*S),
+	}
+	for y := 0; y < S; y++ {
+		e.left = append(e.left, pix[4*S*y:4*S*y+4]...)
+		e.right = append(e.right, pix[4*(S*y+S-1):4*(S*y+S)]...)
+	}
+	return e, nil
+}
+
+// deriveNeighbors fills in the horizontal and vertical propagator from
+// matching tile edges, for every orientation of every tile.
+func (tm *Tiled) deriveNeighbors(tolerance int) error {
+	edges := make([]tileEdges, len(tm.tiles))
+	for t := range edges {
+		var err error
+		if edges[t], err = tm.edges(t); err != nil {
+			return err
+		}
+	}
+
+	match := func(a, b []byte) bool {
+		for i := range a {
+			d := int(a[i]) - int(b[i])
+			if d > tolerance || -d > tolerance {
+				return false
+			}
+		}
+		return true
+	}
+
+	for t1 := range edges {
+		for t2 := range edges {
+			if match(edges[t1].right, edges[t2].left) {
+				tm.propagator[0][t1][t2] = true
+			}
+			// direction 1 holds t1 directly below t2.
+			if match(edges[t1].top, edges[t2].bottom) {
+				tm.propagator[1][t1][t2] = true
+			}
+		}
+	}
+	return nil
+}
+
+// sockets labels the top, right, bottom and left edges of an oriented
+// tile. Each edge is read clockwise around its tile, so the shared edge of
+// two neighbours is read in opposite directions: a symmetric socket
+// matches itself, and an asymmetric one ending in '+' matches the same
+// label ending in '-'.
+type sockets [4]string
+
+// rotate turns the tile a quarter counter-clockwise, like CarTile(1).
+func (s sockets) rotate() sockets {
+	return sockets{s[1], s[2], s[3], s[0]}
+}
+
+// mirror flips the tile left to right, which reverses every edge.
+func (s sockets) mirror() sockets {
+	return sockets{flipSocket(s[0]), flipSocket(s[3]), flipSocket(s[2]), flipSocket(s[1])}
+}
+
+func flipSocket(s string) string {
+	switch {
+	case strings.HasSuffix(s, "+"):
+		return s[:len(s)-1] + "-"
+	case strings.HasSuffix(s, "-"):
+		return s[:len(s)-1] + "+"
+	}
+	return s
+}
+
+func socketsMatch(s1, s2 string) bool { return s1 == flipSocket(s2) }
+
+// socketNeighbors allows every pair of oriented tiles whose facing edge
+// sockets match. Tiles without sockets only get their listed neighbors.
+func (tm *Tiled) socketNeighbors(ts *config.TileSet) error {
+	labels := make([]sockets, len(tm.action))
+	labelled := make([]bool, len(tm.action))
+
+	for _, tile := range ts.Tiles {
+		first, ok := tm.firstOccurrence[tile.Name]
+		if !ok {
+			continue
+		}
+
+		list, err := tile.SocketList()
+		if err != nil {
+			return err
+		}
+		if len(list) == 0 {
+			continue
+		}
+
+		// action k turns the base orientation k times, and action 4+k
+		// mirrors that.
+		s := sockets{list[0], list[1], list[2], list[3]}
+		for k := 0; k < 4; k++ {
+			for _, o := range [2]int{tm.action[first][k], tm.action[first][4+k]} {
+				if !labelled[o] {
+					labels[o], labelled[o] = s, true
+				}
+				s = s.mirror()
+			}
+			s = s.rotate()
+		}
+	}
+
+	for t1 := range labels {
+		for t2 := range labels {
+			if !labelled[t1] || !labelled[t2] {
+				continue
+			}
+			if socketsMatch(labels[t1][1], labels[t2][3]) {
+				tm.propagator[0][t1][t2] = true
+			}
+			if socketsMatch(labels[t1][0], labels[t2][2]) {
+				tm.propagator[1][t1][t2] = true
+			}
+		}
+	}
+	return nil
+}
+
+// NeighborRules expresses the model's propagator as neighbor rules for
+// data.xml, so that derived adjacencies can be reviewed and kept.
+func (tm *Tiled) NeighborRules() []config.Neighbor {
+	T := len(tm.action)
+
+	var covered [2][][]bool
+	for d := range covered {
+		covered[d] = make([][]bool, T)
+		for t := range covered[d] {
+			covered[d][t] = make([]bool, T)
+		}
+	}
+
+	var rules []config.Neighbor
+	add := func(L, R int) {
+		rule := config.Neighbor{Left: tm.tileRef(L), Right: tm.tileRef(R)}
+		if tm.weights[0] != nil && tm.weights[0][L][R] != 1 {
+			rule.Weight = tm.weights[0][L][R]
+		}
+		rules = append(rules, rule)
+		expandNeighbor(tm.action, L, R, func(d, t1, t2 int) {
+			covered[d][t1][t2] = true
+		})
+	}
+
+	for L := 0; L < T; L++ {
+		for R := 0; R < T; R++ {
+			if tm.propagator[0][L][R] && !covered[0][L][R] {
+				add(L, R)
+			}
+		}
+	}
+
+	// vertical pairs not implied by a horizontal rule are rotated back
+	// into one.
+	for D := 0; D < T; D++ {
+		for U := 0; U < T; U++ {
+			if tm.propagator[1][D][U] && !covered[1][D][U] {
+				add(tm.action[D][3], tm.action[U][3])
+			}
+		}
+	}
+
+	return rules
+}
+
+// tileRef names orientation t as a data.xml tile reference.
+func (tm *Tiled) tileRef(t int) string {
+	name, k := tm.orientation(t)
+	if k == 0 {
+		return name
+	}
+	return name + " " + strconv.Itoa(k)
+}
+
+// orientation returns the tile of orientation t and the least action that
+// turns its first orientation into t.
+func (tm *Tiled) orientation(t int) (name string, k int) {
+	name = tm.names[t]
+	first := tm.firstOccurrence[name]
+	for k, o := range tm.action[first] {
+		if o == t {
+			return name, k
+		}
+	}
+	return name, 0
+}
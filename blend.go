@@ -0,0 +1,125 @@
+package bohm
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+	"strings"
+	"sync"
+)
+
+// Blend selects how Graphics renders cells that are not yet collapsed.
+type Blend int
+
+const (
+	// BlendAverage averages the colours of every remaining state.
+	BlendAverage Blend = iota
+
+	// BlendMostProbable draws the colour with the greatest weight.
+	BlendMostProbable
+
+	// BlendLinear averages like BlendAverage but in linear light rather
+	// than directly on sRGB values.
+	BlendLinear
+)
+
+func ParseBlend(s string) (Blend, error) {
+	switch strings.ToLower(s) {
+	case "", "average":
+		return BlendAverage, nil
+	case "most-probable", "mostprobable":
+		return BlendMostProbable, nil
+	case "linear":
+		return BlendLinear, nil
+	}
+	return 0, fmt.Errorf("bohm: unknown blend %q", s)
+}
+
+// blender accumulates weighted colours. Channels are summed premultiplied
+// so transparent contributors only thin out the alpha of the result.
+type blender struct {
+	linear bool
+
+	r, g, b, a, w uint64
+}
+
+func (bl *blender) reset() {
+	bl.r, bl.g, bl.b, bl.a, bl.w = 0, 0, 0, 0, 0
+}
+
+// add accumulates a premultiplied 16-bit colour, as returned by
+// color.Color.RGBA, with weight w.
+func (bl *blender) add(r, g, b, a uint32, w uint64) {
+	bl.w += w
+	if a == 0 {
+		return
+	}
+
+	if bl.linear {
+		lin := linearTables()
+		r = lin.toLinear[r*0xffff/a>>8] * a / 0xffff
+		g = lin.toLinear[g*0xffff/a>>8] * a / 0xffff
+		b = lin.toLinear[b*0xffff/a>>8] * a / 0xffff
+	}
+
+	bl.r += uint64(r) * w
+	bl.g += uint64(g) * w
+	bl.b += uint64(b) * w
+	bl.a += uint64(a) * w
+}
+
+// rgba returns the weighted mean of the accumulated colours.
+func (bl *blender) rgba() color.RGBA {
+	if bl.w == 0 {
+		return color.RGBA{}
+	}
+
+	r, g, b, a := bl.r/bl.w, bl.g/bl.w, bl.b/bl.w, bl.a/bl.w
+
+	if bl.linear && a != 0 {
+		lin := linearTables()
+		r = uint64(lin.fromLinear[r*0xffff/a]) * 0x101 * a / 0xffff
+		g = uint64(lin.fromLinear[g*0xffff/a]) * 0x101 * a / 0xffff
+		b = uint64(lin.fromLinear[b*0xffff/a]) * 0x101 * a / 0xffff
+	}
+
+	return color.RGBA{R: uint8(r >> 8), G: uint8(g >> 8), B: uint8(b >> 8), A: uint8(a >> 8)}
+}
+
+type linearTable struct {
+	toLinear   [0x100]uint32
+	fromLinear [0x10000]uint8
+}
+
+var (
+	linearOnce sync.Once
+	linearLUT  *linearTable
+)
+
+// linearTables returns the sRGB transfer function tables, building them on
+// first use.
+func linearTables() *linearTable {
+	linearOnce.Do(func() {
+		linearLUT = new(linearTable)
+		for i := range linearLUT.toLinear {
+			c := float64(i) / 0xff
+			if c <= 0.04045 {
+				c /= 12.92
+			} else {
+				c = math.Pow((c+0.055)/1.055, 2.4)
+			}
+			linearLUT.toLinear[i] = uint32(math.Round(c * 0xffff))
+		}
+
+		for i := range linearLUT.fromLinear {
+			c := float64(i) / 0xffff
+			if c <= 0.0031308 {
+				c *= 12.92
+			} else {
+				c = 1.055*math.Pow(c, 1/2.4) - 0.055
+			}
+			linearLUT.fromLinear[i] = uint8(math.Round(c * 0xff))
+		}
+	})
+	return linearLUT
+}
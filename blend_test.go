@@ -0,0 +1,35 @@
+package bohm
+
+import (
+	"image/color"
+	"testing"
+)
+
+func TestBlender(t *testing.T) {
+	tests := []struct {
+		name   string
+		linear bool
+		colors []color.Color
+		want   color.RGBA
+	}{
+		{"single", false, []color.Color{color.RGBA{0x12, 0x34, 0x56, 0xff}}, color.RGBA{0x12, 0x34, 0x56, 0xff}},
+		{"single linear", true, []color.Color{color.RGBA{0x12, 0x34, 0x56, 0xff}}, color.RGBA{0x12, 0x34, 0x56, 0xff}},
+		{"average", false, []color.Color{testBlack, testWhite}, color.RGBA{0x7f, 0x7f, 0x7f, 0xff}},
+		{"linear", true, []color.Color{testBlack, testWhite}, color.RGBA{0xbc, 0xbc, 0xbc, 0xff}},
+		{"transparent", false, []color.Color{testRed, color.NRGBA{0x00, 0xff, 0x00, 0x00}}, color.RGBA{0x7f, 0x00, 0x00, 0x7f}},
+		{"transparent linear", true, []color.Color{testRed, color.NRGBA{0x00, 0xff, 0x00, 0x00}}, color.RGBA{0x7f, 0x00, 0x00, 0x7f}},
+		{"empty", false, nil, color.RGBA{}},
+	}
+
+	for _, tt := range tests {
+		bl := blender{linear: tt.linear}
+		for _, c := range tt.colors {
+			r, g, b, a := c.RGBA()
+			bl.add(r, g, b, a, 1)
+		}
+
+		if got := bl.rgba(); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
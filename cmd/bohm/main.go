@@ -1,9 +1,11 @@
 package main
 
 import (
+	"encoding/xml"
 	"flag"
 	"fmt"
 	"image"
+	"image/color"
 	"log"
 	"math/rand"
 	"os"
@@ -24,7 +26,7 @@ var (
 		Width:         48,
 		Height:        48,
 		PeriodicInput: true,
-		Symmetry:      8,
+		Symmetry:      "all",
 		Screenshots:   2,
 	}
 	tiledDefaults = config.Defaults{
@@ -36,12 +38,21 @@ var (
 
 var random *rand.Rand
 
+var exportFormat *bohm.MapFormat
+
 func init() {
 	flag.StringVar(&inputFile, "i", "samples.xml", "xml file containing texture jobs")
 	flag.StringVar(&textureDir, "t", "samples", "directory containing texture images")
 	flag.StringVar(&outputDir, "o", "./out", "output directory")
 
 	flag.StringVar(&filterStr, "f", "", "only run jobs matching argument")
+	flag.StringVar(&exportStr, "export", "", "also save simpletiled results as tmx or json maps for the Tiled editor")
+	flag.BoolVar(&printStats, "stats", false, "log pattern statistics of overlapping jobs")
+	flag.BoolVar(&paletted, "paletted", false, "save overlapping results using the sample palette")
+	flag.BoolVar(&debug, "debug", false, "draw simpletiled results as labelled flat colours, without tile images")
+	flag.BoolVar(&validate, "validate", false, "check the tilesets of simpletiled jobs instead of running them")
+	flag.BoolVar(&detectSymmetry, "detect", false, "detect missing tile symmetry classes and warn about wrong ones")
+	flag.BoolVar(&printNeighbors, "neighbors", false, "print the neighbor rules of simpletiled jobs as xml")
 
 	flag.Parse()
 
@@ -54,12 +65,24 @@ func init() {
 		panic(err)
 	}
 
+	if exportStr != "" {
+		format, err := bohm.ParseMapFormat(exportStr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		exportFormat = &format
+	}
+
 	seed := time.Now().UnixNano()
 	random = rand.New(rand.NewSource(seed))
 	log.Printf("SEED: %q\n", shortening.Encode(uint64(seed)))
 }
 
-var inputFile, textureDir, outputDir, filterStr string
+var (
+	inputFile, textureDir, outputDir, filterStr, exportStr string
+
+	printStats, paletted, printNeighbors, detectSymmetry, validate, debug bool
+)
 
 func main() {
 	cfg, err := config.Read(inputFile)
@@ -79,23 +102,138 @@ func main() {
 		var m interface {
 			Run(seed int64, limit int) bool
 			Graphics() (image.Image, error)
+			Pin(bohm.EdgeRule)
+			Indices(spec string) ([]int, error)
+		}
+
+		blend, err := bohm.ParseBlend(s.Blend)
+		if err != nil {
+			log.Println(name, err)
+			continue
 		}
 
 		switch s.XMLName.Local {
 		case "overlapping":
 			s.Set(overlappingDefaults)
-			m = bohm.NewOverlapping(textureDir, s.Name, s.N,
-				s.Width, s.Height, *s.PeriodicInput, s.Periodic, s.Symmetry, s.Ground)
+
+			symmetry, err := bohm.ParseSymmetry(s.Symmetry)
+			if err != nil {
+				log.Println(name, err)
+				continue
+			}
+
+			var ignore color.Color
+			if s.Ignore != "" {
+				if ignore, err = bohm.ParseColor(s.Ignore); err != nil {
+					log.Println(name, err)
+					continue
+				}
+			}
+
+			ignoreMode, err := bohm.ParseIgnoreMode(s.IgnoreMode)
+			if err != nil {
+				log.Println(name, err)
+				continue
+			}
+
+			var samples []bohm.OverlappingSample
+			for _, img := range s.Images {
+				samples = append(samples, bohm.OverlappingSample{
+					Name:     img.Name,
+					Weight:   img.Weight,
+					Periodic: bohm.Periodicity{X: *img.PeriodicInputX, Y: *img.PeriodicInputY},
+				})
+			}
+
+			om, err := bohm.NewOverlappingSamples(textureDir, samples, bohm.OverlappingOptions{
+				N:        s.N,
+				Width:    s.Width,
+				Height:   s.Height,
+				Periodic: bohm.Periodicity{X: *s.PeriodicX, Y: *s.PeriodicY},
+				Symmetry: symmetry,
+				Ground:   s.Ground,
+
+				Ignore:     ignore,
+				IgnoreMode: ignoreMode,
+			})
+			if err != nil {
+				log.Println(name, err)
+				continue
+			}
+
+			if printStats {
+				st := om.Stats()
+				log.Printf("%s: T=%d colors=%d density=%.3f propagator=%dB wave=%dB\n",
+					name, st.T, st.Colors, st.PropagatorDensity, st.PropagatorBytes, st.WaveBytes)
+			}
+			om.Blend = blend
+			m = om
 
 		case "simpletiled":
 			s.Set(tiledDefaults)
-			m = bohm.NewTiled(textureDir, s.Name, s.Subset, s.Width, s.Height, s.Periodic, s.Black)
+
+			if validate {
+				problems, err := bohm.ValidateTileset(os.DirFS(textureDir), s.Name)
+				if err != nil {
+					log.Println(name, err)
+				}
+				for _, p := range problems {
+					log.Printf("%s/%s\n", s.Name, p)
+				}
+				continue
+			}
+
+			weights := make(map[string]float64)
+			for _, w := range s.Weights {
+				weights[w.Tile] = w.Value
+			}
+
+			var regions []bohm.Region
+			for _, r := range s.Regions {
+				regions = append(regions, bohm.Region{
+					Rect:   image.Rect(r.X, r.Y, r.X+r.Width, r.Y+r.Height),
+					Subset: r.Subset,
+				})
+			}
+
+			tm, err := bohm.NewTiledDir(textureDir, s.Name, bohm.TiledOptions{
+				Subset:   s.Subset,
+				WangSet:  s.WangSet,
+				Weights:  weights,
+				Regions:  regions,
+				Width:    s.Width,
+				Height:   s.Height,
+				Periodic: bohm.Periodicity{X: *s.PeriodicX, Y: *s.PeriodicY},
+				Black:    s.Black,
+
+				DetectSymmetry: detectSymmetry,
+				Warnf:          log.Printf,
+			})
+			if err != nil {
+				log.Println(name, err)
+				continue
+			}
+			if printNeighbors {
+				out, err := xml.MarshalIndent(tm.NeighborRules(), "", "\t")
+				if err != nil {
+					log.Println(name, err)
+					continue
+				}
+				fmt.Printf("<neighbors>\n%s\n</neighbors>\n", out)
+			}
+			tm.Blend = blend
+			m = tm
 
 		default:
 			log.Println(s.XMLName.Local, "not implemented")
 			continue
 		}
 
+		if err := pinEdges(m, s.Edges); err != nil {
+			log.Println(name, err)
+			continue
+		}
+
 		for i := 0; i < s.Screenshots; i++ {
 			for k := 0; k < 10; k++ {
 				seed := random.Int63()
@@ -103,12 +241,26 @@ func main() {
 				if m.Run(seed, s.Limit) {
 					log.Printf("[%s]\tDONE\n", ident)
 
-					img, err := m.Graphics()
+					var img image.Image
+					if om, ok := m.(*bohm.Overlapping); ok && paletted {
+						img, err = om.Paletted()
+					} else if tm, ok := m.(*bohm.Tiled); ok && debug {
+						img = tm.DebugImage(bohm.DebugOptions{CellSize: 32, Arrows: true, Labels: true})
+						err = os.WriteFile(filepath.Join(outputDir, ident+".txt"), []byte(tm.DebugText()), 0644)
+					} else {
+						img, err = m.Graphics()
+					}
 					if err != nil {
 						panic(err)
 					}
 
 					saveImage(filepath.Join(outputDir, ident+".png"), img)
+
+					if tm, ok := m.(*bohm.Tiled); ok && exportFormat != nil {
+						if err := exportMap(tm, *exportFormat, s.Name, ident); err != nil {
+							log.Println(name, err)
+						}
+					}
 					break
 				} else {
 					log.Printf("[%s]\tCONTRADICTION\n", ident)
@@ -119,6 +271,61 @@ func main() {
 	}
 }
 
+func pinEdges(m interface {
+	Pin(bohm.EdgeRule)
+	Indices(spec string) ([]int, error)
+}, rules []config.EdgeRule) error {
+	for _, r := range rules {
+		edges, err := bohm.ParseEdges(r.Sides)
+		if err != nil {
+			return err
+		}
+
+		allowed, err := m.Indices(r.Allowed)
+		if err != nil {
+			return err
+		}
+
+		m.Pin(bohm.EdgeRule{Edges: edges, Allowed: allowed, Exclusive: r.Exclusive})
+	}
+	return nil
+}
+
+// exportMap saves a tiled result as a map, next to a tileset shared by the
+// results of the same job.
+func exportMap(tm *bohm.Tiled, format bohm.MapFormat, name, ident string) error {
+	tileset := name + format.TilesetExt()
+
+	imageDir, err := filepath.Rel(outputDir, textureDir)
+	if err != nil {
+		if imageDir, err = filepath.Abs(textureDir); err != nil {
+			return err
+		}
+	}
+
+	if err := writeFile(filepath.Join(outputDir, tileset), func(f *os.File) error {
+		return tm.WriteMapTileset(f, format, name, filepath.ToSlash(imageDir))
+	}); err != nil {
+		return err
+	}
+
+	return writeFile(filepath.Join(outputDir, ident+format.MapExt()), func(f *os.File) error {
+		return tm.WriteMap(f, format, tileset)
+	})
+}
+
+func writeFile(name string, write func(*os.File) error) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
 func saveImage(name string, img image.Image) {
 	f, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY, 0755)
 	if err != nil {
@@ -0,0 +1,49 @@
+// Command tilesym detects the symmetry class of the tiles of simpletiled
+// tilesets and reports those whose declared class disagrees.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"vallon.me/bohm"
+)
+
+func main() {
+	all := flag.Bool("a", false, "list every tile, not only mismatches")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: tilesym [-a] tileset-dir...")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	status := 0
+	for _, dir := range flag.Args() {
+		tiles, err := bohm.DetectTileSymmetry(os.DirFS(filepath.Dir(dir)), filepath.Base(dir))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			status = 1
+			continue
+		}
+
+		for _, t := range tiles {
+			switch {
+			case t.Declared == "":
+				fmt.Printf("%s: <tile name=%q symmetry=%q/> (missing)\n", dir, t.Name, t.Detected)
+			case t.Declared != t.Detected:
+				fmt.Printf("%s: <tile name=%q symmetry=%q/> (declared %s)\n", dir, t.Name, t.Detected, t.Declared)
+				status = 1
+			case *all:
+				fmt.Printf("%s: <tile name=%q symmetry=%q/>\n", dir, t.Name, t.Detected)
+			}
+		}
+	}
+	os.Exit(status)
+}
@@ -2,7 +2,9 @@ package config
 
 import (
 	"encoding/xml"
+	"io/fs"
 	"os"
+	"path/filepath"
 )
 
 type MainConfig struct {
@@ -19,17 +21,67 @@ type Sample struct {
 	N     int `xml:"N,attr"`
 	Limit int `xml:"limit,attr"`
 
-	Symmetry int `xml:"symmetry,attr"`
-	Ground   int `xml:"ground,attr"`
+	Symmetry string `xml:"symmetry,attr"`
+	Ground   int    `xml:"ground,attr"`
+
+	Ignore     string `xml:"ignore,attr"`
+	IgnoreMode string `xml:"ignoreMode,attr"`
 
 	Screenshots int `xml:"screenshots,attr"`
 
-	Subset string `xml:"subset,attr"`
+	Subset  string `xml:"subset,attr"`
+	WangSet string `xml:"wangset,attr"`
+
+	Black bool   `xml:"black,attr"`
+	Blend string `xml:"blend,attr"`
+
+	Periodic  bool  `xml:"periodic,attr"`
+	PeriodicX *bool `xml:"periodicX,attr"`
+	PeriodicY *bool `xml:"periodicY,attr"`
+
+	PeriodicInput  *bool `xml:"periodicInput,attr"`
+	PeriodicInputX *bool `xml:"periodicInputX,attr"`
+	PeriodicInputY *bool `xml:"periodicInputY,attr"`
+
+	Images  []SampleImage `xml:"image"`
+	Edges   []EdgeRule    `xml:"edge"`
+	Weights []TileWeight  `xml:"weight"`
+	Regions []Region      `xml:"region"`
+}
+
+// SampleImage is one of several source images learned by a single
+// overlapping job.
+type SampleImage struct {
+	Name   string  `xml:"name,attr"`
+	Weight float64 `xml:"weight,attr"`
+
+	PeriodicInput  *bool `xml:"periodicInput,attr"`
+	PeriodicInputX *bool `xml:"periodicInputX,attr"`
+	PeriodicInputY *bool `xml:"periodicInputY,attr"`
+}
 
-	Black bool `xml:"black,attr"`
+// EdgeRule pins the cells along Sides (e.g. "left right") to the comma
+// separated patterns or tiles in Allowed.
+type EdgeRule struct {
+	Sides     string `xml:"sides,attr"`
+	Allowed   string `xml:"allowed,attr"`
+	Exclusive bool   `xml:"exclusive,attr"`
+}
 
-	Periodic      bool  `xml:"periodic,attr"`
-	PeriodicInput *bool `xml:"periodicInput,attr"`
+// TileWeight overrides the weight of a tile in a simpletiled job.
+type TileWeight struct {
+	Tile  string  `xml:"tile,attr"`
+	Value float64 `xml:"value,attr"`
+}
+
+// Region restricts a rectangle of cells of a simpletiled job to the tiles
+// of a subset expression.
+type Region struct {
+	X      int    `xml:"x,attr"`
+	Y      int    `xml:"y,attr"`
+	Width  int    `xml:"width,attr"`
+	Height int    `xml:"height,attr"`
+	Subset string `xml:"subset,attr"`
 }
 
 func (s *Sample) Set(defaults Defaults) {
@@ -44,11 +96,29 @@ func (s *Sample) Set(defaults Defaults) {
 		s.Height = defaults.Height
 	}
 
-	if s.PeriodicInput == nil {
-		s.PeriodicInput = &defaults.PeriodicInput
+	// the per-axis attributes default to their two-axis shorthand.
+	setBool(&s.PeriodicX, &s.Periodic)
+	setBool(&s.PeriodicY, &s.Periodic)
+
+	setBool(&s.PeriodicInput, &defaults.PeriodicInput)
+	setBool(&s.PeriodicInputX, s.PeriodicInput)
+	setBool(&s.PeriodicInputY, s.PeriodicInput)
+
+	if len(s.Images) == 0 {
+		s.Images = []SampleImage{{Name: s.Name}}
+	}
+	for i := range s.Images {
+		img := &s.Images[i]
+		if img.PeriodicInput == nil {
+			setBool(&img.PeriodicInputX, s.PeriodicInputX)
+			setBool(&img.PeriodicInputY, s.PeriodicInputY)
+		} else {
+			setBool(&img.PeriodicInputX, img.PeriodicInput)
+			setBool(&img.PeriodicInputY, img.PeriodicInput)
+		}
 	}
 
-	if s.Symmetry == 0 {
+	if s.Symmetry == "" {
 		s.Symmetry = defaults.Symmetry
 	}
 	if s.Screenshots == 0 {
@@ -56,8 +126,18 @@ func (s *Sample) Set(defaults Defaults) {
 	}
 }
 
+func setBool(p **bool, def *bool) {
+	if *p == nil {
+		*p = def
+	}
+}
+
 func Read(name string) (*MainConfig, error) {
-	f, err := os.Open(name)
+	return ReadFS(os.DirFS(filepath.Dir(name)), filepath.Base(name))
+}
+
+func ReadFS(fsys fs.FS, name string) (*MainConfig, error) {
+	f, err := fsys.Open(name)
 	if err != nil {
 		return nil, err
 	}
@@ -72,8 +152,10 @@ func Read(name string) (*MainConfig, error) {
 }
 
 type Defaults struct {
-	N, Width, Height      int
-	Symmetry, Screenshots int
+	N, Width, Height int
+	Screenshots      int
+
+	Symmetry string
 
 	PeriodicInput bool
 }
@@ -2,69 +2,303 @@ package config
 
 import (
 	"encoding/xml"
+	"fmt"
+	"image"
+	"io"
+	"io/fs"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 )
 
-func ReadTileData(name string) *tileSet {
-	f, err := os.Open(name)
+func ReadTileData(name string) *TileSet {
+	ts, err := ReadTileDataFS(os.DirFS(filepath.Dir(name)), filepath.Base(name))
 	if err != nil {
 		panic(err)
 	}
+	return ts
+}
+
+func ReadTileDataFS(fsys fs.FS, name string) (*TileSet, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
 	defer f.Close()
 
 	dec := xml.NewDecoder(f)
 
-	var ts tileSet
+	var ts TileSet
 	if err := dec.Decode(&ts); err != nil {
-		panic(err)
+		return nil, err
 	}
 
-	return &ts
+	return &ts, nil
 }
 
-type tile struct {
+// Tile is a tile of a tileset, whose orientations follow from its
+// symmetry class.
+type Tile struct {
 	Name     string  `xml:"name,attr"`
-	Symmetry string  `xml:"symmetry,attr"`
-	Weight   float64 `xml:"weight,attr"`
+	Symmetry string  `xml:"symmetry,attr,omitempty"`
+	Weight   float64 `xml:"weight,attr,omitempty"`
+
+	// Sockets labels the top, right, bottom and left edges of the tile,
+	// as an alternative to listing its neighbors.
+	Sockets string `xml:"sockets,attr,omitempty"`
+
+	// Cell lists the atlas cells of the tile, one per orientation in a
+	// unique tileset.
+	Cell string `xml:"cell,attr,omitempty"`
+
+	// Image is the file of the tile image relative to the tileset, if it
+	// is not the tile name followed by ".png".
+	Image string `xml:"image,attr,omitempty"`
+
+	// Images holds the tile image, or one per orientation in a unique
+	// tileset, for tilesets built in code. They are not written to XML.
+	Images []image.Image `xml:"-"`
+
+	// Line is the line of data.xml the tile was read from.
+	Line int `xml:"-"`
 }
 
-type tileSet struct {
-	Size      int    `xml:"size,attr"`
-	Unique    bool   `xml:"unique,attr"`
-	Tiles     []tile `xml:"tiles>tile"`
-	Neighbors []struct {
-		Left  string `xml:"left,attr"`
-		Right string `xml:"right,attr"`
-	} `xml:"neighbors>neighbor"`
-	Subsets []struct {
-		Name  string `xml:"name,attr"`
-		Tiles []tile `xml:"tile"`
-	} `xml:"subsets>subset"`
+func (t *Tile) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	type plain Tile
+	t.Line, _ = d.InputPos()
+	return d.DecodeElement((*plain)(t), &start)
 }
 
-func (ts tileSet) SubsetList(subset string) (set setList) {
-	if subset == "" {
-		return
+// Cells parses the atlas cell indices of the tile.
+func (t Tile) Cells() ([]int, error) {
+	var cells []int
+	for _, f := range strings.Fields(t.Cell) {
+		c, err := strconv.Atoi(f)
+		if err != nil {
+			return nil, fmt.Errorf("config: tile %q: bad cell %q", t.Name, f)
+		}
+		cells = append(cells, c)
 	}
+	return cells, nil
+}
 
-	for _, ss := range ts.Subsets {
-		if ss.Name == subset {
-			for _, t := range ss.Tiles {
-				set = append(set, t.Name)
+// SocketList parses the edge sockets of the tile, top first and clockwise.
+// It returns an empty list if the tile has none.
+func (t Tile) SocketList() ([]string, error) {
+	sockets := strings.Fields(t.Sockets)
+	if len(sockets) != 0 && len(sockets) != 4 {
+		return nil, fmt.Errorf("config: tile %q: %d sockets, want 4", t.Name, len(sockets))
+	}
+	return sockets, nil
+}
+
+// Atlas is a single image holding every tile on a grid. Cells are counted
+// in row-major order from the top left. TileSize is the size of a cell,
+// which defaults to the tileset size and must agree with it if both are
+// given.
+type Atlas struct {
+	Image    string `xml:"image,attr"`
+	TileSize int    `xml:"tilesize,attr,omitempty"`
+	Spacing  int    `xml:"spacing,attr,omitempty"`
+	Margin   int    `xml:"margin,attr,omitempty"`
+}
+
+// Neighbor allows Right directly to the right of Left. Each side is a
+// tile name optionally followed by a space and an orientation, from 0 up to
+// the number of orientations of its symmetry class.
+type Neighbor struct {
+	XMLName xml.Name `xml:"neighbor"`
+	Left    string   `xml:"left,attr"`
+	Right   string   `xml:"right,attr"`
+
+	// Weight makes the pair more or less likely once one of them is
+	// placed. 0 is the neutral weight of 1.
+	Weight float64 `xml:"weight,attr,omitempty"`
+
+	// Line is the line of data.xml the rule was read from.
+	Line int `xml:"-"`
+}
+
+func (n *Neighbor) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	type plain Neighbor
+	n.Line, _ = d.InputPos()
+	return d.DecodeElement((*plain)(n), &start)
+}
+
+// TileSet is the contents of a tileset's data.xml.
+type TileSet struct {
+	Size   int    `xml:"size,attr"`
+	Unique bool   `xml:"unique,attr,omitempty"`
+	Atlas  *Atlas `xml:"atlas"`
+
+	// Adjacency "auto" derives neighbor rules from the tile edges, with
+	// colour channels matching if they differ by at most Tolerance.
+	Adjacency string `xml:"adjacency,attr,omitempty"`
+	Tolerance int    `xml:"tolerance,attr,omitempty"`
+
+	Tiles     []Tile     `xml:"tiles>tile"`
+	Neighbors []Neighbor `xml:"neighbors>neighbor"`
+	Subsets   []Subset   `xml:"subsets>subset"`
+}
+
+// Subset names a group of tiles a model can be restricted to.
+type Subset struct {
+	Name  string `xml:"name,attr"`
+	Tiles []Tile `xml:"tile"`
+}
+
+// AddTile adds a tile with its symmetry class and weight, and optionally
+// its images. A weight of 0 is the default weight of 1. Tiles with other
+// attributes, such as sockets, can be appended to Tiles directly.
+func (ts *TileSet) AddTile(name, symmetry string, weight float64, images ...image.Image) {
+	ts.Tiles = append(ts.Tiles, Tile{
+		Name:     name,
+		Symmetry: symmetry,
+		Weight:   weight,
+		Images:   images,
+	})
+}
+
+// AddNeighbor allows right directly to the right of left. Both are tile
+// names, optionally followed by a space and an orientation as in Neighbor.
+func (ts *TileSet) AddNeighbor(left, right string) {
+	ts.Neighbors = append(ts.Neighbors, Neighbor{Left: left, Right: right})
+}
+
+// AddSubset defines a subset of the named tiles.
+func (ts *TileSet) AddSubset(name string, tiles ...string) {
+	ss := Subset{Name: name}
+	for _, t := range tiles {
+		ss.Tiles = append(ss.Tiles, Tile{Name: t})
+	}
+	ts.Subsets = append(ts.Subsets, ss)
+}
+
+// WriteXML writes the tileset in the format of data.xml. Tile images are
+// not written, and must be saved as the files data.xml refers to.
+func (ts *TileSet) WriteXML(w io.Writer) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "\t")
+	if err := enc.EncodeElement(ts, xml.StartElement{Name: xml.Name{Local: "set"}}); err != nil {
+		return err
+	}
+	if err := enc.Flush(); err != nil {
+		return err
+	}
+
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// SubsetList resolves a subset expression to the tiles it selects. The
+// expression combines subset names from left to right with " + " (union),
+// " & " (intersection) and " - " (exclusion); the operators need spaces
+// around them, as names may contain dashes. An operand that is not a
+// subset may name a single tile, and "*" stands for every tile. An empty
+// expression selects nothing, which callers read as the whole tileset.
+func (ts TileSet) SubsetList(expr string) (Selection, error) {
+	if strings.TrimSpace(expr) == "" {
+		return nil, nil
+	}
+
+	var set Selection
+	op, rest := byte('+'), expr
+	for {
+		operand, after, next := cutOperator(rest)
+
+		s, err := ts.operand(strings.TrimSpace(operand))
+		if err != nil {
+			return nil, err
+		}
+
+		switch op {
+		case '+':
+			if set == nil {
+				set = make(Selection)
+			}
+			for name, w := range s {
+				if _, ok := set[name]; !ok || w != 0 {
+					set[name] = w
+				}
 			}
+		case '&':
+			for name := range set {
+				if w, ok := s[name]; !ok {
+					delete(set, name)
+				} else if w != 0 {
+					set[name] = w
+				}
+			}
+		case '-':
+			for name := range s {
+				delete(set, name)
+			}
+		}
+
+		if next == 0 {
 			break
 		}
+		op, rest = next, after
 	}
-	return
+
+	if len(set) == 0 {
+		return nil, fmt.Errorf("config: subset %q selects no tiles", expr)
+	}
+	return set, nil
 }
 
-type setList []string
+// cutOperator splits expr around its first subset operator, returning 0
+// if there is none.
+func cutOperator(expr string) (before, after string, op byte) {
+	i := -1
+	for _, o := range []string{" + ", " & ", " - "} {
+		if j := strings.Index(expr, o); j >= 0 && (i < 0 || j < i) {
+			i, op = j, o[1]
+		}
+	}
+	if i < 0 {
+		return expr, "", 0
+	}
+	return expr[:i], expr[i+3:], op
+}
+
+func (ts TileSet) operand(name string) (Selection, error) {
+	set := make(Selection)
+	if name == "*" {
+		for _, t := range ts.Tiles {
+			set[t.Name] = 0
+		}
+		return set, nil
+	}
+
+	for _, ss := range ts.Subsets {
+		if ss.Name == name {
+			for _, t := range ss.Tiles {
+				set[t.Name] = t.Weight
+			}
+			return set, nil
+		}
+	}
 
-func (l setList) Contains(name string) bool {
-	for _, s := range l {
-		if s == name {
-			return true
+	for _, t := range ts.Tiles {
+		if t.Name == name {
+			set[name] = 0
+			return set, nil
 		}
 	}
-	return false
+	return nil, fmt.Errorf("config: unknown subset %q", name)
+}
+
+// Selection holds the tiles selected by a subset expression, with the
+// weight their subset gives them, or 0 to keep the tile's own weight.
+type Selection map[string]float64
+
+func (s Selection) Contains(name string) bool {
+	_, ok := s[name]
+	return ok
 }
@@ -0,0 +1,185 @@
+package config
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/fs"
+	"strconv"
+	"strings"
+)
+
+type tsxTileset struct {
+	TileWidth  int `xml:"tilewidth,attr"`
+	TileHeight int `xml:"tileheight,attr"`
+	Spacing    int `xml:"spacing,attr"`
+	Margin     int `xml:"margin,attr"`
+
+	Image *tsxImage `xml:"image"`
+	Tiles []tsxTile `xml:"tile"`
+
+	WangSets []tsxWangSet `xml:"wangsets>wangset"`
+	Terrains []struct {
+		Name string `xml:"name,attr"`
+	} `xml:"terraintypes>terrain"`
+}
+
+type tsxImage struct {
+	Source string `xml:"source,attr"`
+}
+
+type tsxTile struct {
+	ID          int       `xml:"id,attr"`
+	Probability float64   `xml:"probability,attr"`
+	Terrain     string    `xml:"terrain,attr"`
+	Image       *tsxImage `xml:"image"`
+}
+
+type tsxWangSet struct {
+	Name  string `xml:"name,attr"`
+	Tiles []struct {
+		TileID int    `xml:"tileid,attr"`
+		WangID string `xml:"wangid,attr"`
+	} `xml:"wangtile"`
+}
+
+// ReadTSX reads a tileset of the Tiled map editor, turning the tiles of
+// one of its Wang sets into tiles whose sockets are the Wang colours of
+// their edges and corners. The first Wang set is used if wangset is
+// empty. Tilesets without Wang sets may use the older terrain corners.
+//
+// Tiles are named by their id and never turned or mirrored. The tileset
+// image is used as an atlas, or each tile has its own image; either is
+// relative to the directory of the tileset file.
+func ReadTSX(fsys fs.FS, name, wangset string) (*TileSet, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var tsx tsxTileset
+	if err := xml.NewDecoder(f).Decode(&tsx); err != nil {
+		return nil, err
+	}
+	if tsx.TileWidth != tsx.TileHeight {
+		return nil, fmt.Errorf("config: %s: tiles are %dx%d, not square", name, tsx.TileWidth, tsx.TileHeight)
+	}
+
+	// colours lists the Wang colours of each tile clockwise from the top
+	// edge: top, top right, right, ... top left. 0 is no colour.
+	colours := make(map[int][8]int)
+	var ids []int
+
+	switch {
+	case len(tsx.WangSets) != 0:
+		set := &tsx.WangSets[0]
+		if wangset != "" {
+			set = nil
+			for i := range tsx.WangSets {
+				if tsx.WangSets[i].Name == wangset {
+					set = &tsx.WangSets[i]
+				}
+			}
+			if set == nil {
+				return nil, fmt.Errorf("config: %s: no wang set %q", name, wangset)
+			}
+		}
+
+		for _, wt := range set.Tiles {
+			var c [8]int
+			fields := strings.Split(wt.WangID, ",")
+			if len(fields) != len(c) {
+				return nil, fmt.Errorf("config: %s: tile %d: bad wangid %q", name, wt.TileID, wt.WangID)
+			}
+			for i, f := range fields {
+				if c[i], err = strconv.Atoi(strings.TrimSpace(f)); err != nil {
+					return nil, fmt.Errorf("config: %s: tile %d: bad wangid %q", name, wt.TileID, wt.WangID)
+				}
+			}
+			colours[wt.TileID] = c
+			ids = append(ids, wt.TileID)
+		}
+
+	case len(tsx.Terrains) != 0:
+		for _, t := range tsx.Tiles {
+			if t.Terrain == "" {
+				continue
+			}
+
+			// terrain lists the top left, top right, bottom left and
+			// bottom right corners, counted from 0.
+			var corners [4]int
+			fields := strings.Split(t.Terrain, ",")
+			if len(fields) != len(corners) {
+				return nil, fmt.Errorf("config: %s: tile %d: bad terrain %q", name, t.ID, t.Terrain)
+			}
+			for i, f := range fields {
+				if f == "" {
+					continue
+				}
+				n, err := strconv.Atoi(f)
+				if err != nil {
+					return nil, fmt.Errorf("config: %s: tile %d: bad terrain %q", name, t.ID, t.Terrain)
+				}
+				corners[i] = n + 1
+			}
+			colours[t.ID] = [8]int{1: corners[1], 3: corners[3], 5: corners[2], 7: corners[0]}
+			ids = append(ids, t.ID)
+		}
+
+	default:
+		return nil, fmt.Errorf("config: %s: no wang sets or terrains", name)
+	}
+
+	ts := &TileSet{Size: tsx.TileWidth}
+	if tsx.Image != nil {
+		ts.Atlas = &Atlas{Image: tsx.Image.Source, TileSize: tsx.TileWidth, Spacing: tsx.Spacing, Margin: tsx.Margin}
+	}
+
+	tiles := make(map[int]tsxTile)
+	for _, t := range tsx.Tiles {
+		tiles[t.ID] = t
+	}
+
+	for _, id := range ids {
+		c := colours[id]
+		tile := Tile{
+			Name:     strconv.Itoa(id),
+			Symmetry: "X",
+			Weight:   tiles[id].Probability,
+			Sockets: strings.Join([]string{
+				wangSocket(c[7], c[0], c[1]),
+				wangSocket(c[1], c[2], c[3]),
+				wangSocket(c[3], c[4], c[5]),
+				wangSocket(c[5], c[6], c[7]),
+			}, " "),
+		}
+
+		if ts.Atlas != nil {
+			tile.Cell = tile.Name
+		} else if img := tiles[id].Image; img != nil {
+			tile.Image = img.Source
+		} else {
+			return nil, fmt.Errorf("config: %s: tile %d has no image", name, id)
+		}
+		ts.Tiles = append(ts.Tiles, tile)
+	}
+	return ts, nil
+}
+
+// wangSocket labels an edge by the Wang colours along it, read clockwise
+// around the tile. A label that reads differently backwards ends in '+'
+// or '-' depending on the direction, so that it matches its reverse.
+func wangSocket(c ...int) string {
+	s := fmt.Sprintf("%d.%d.%d", c[0], c[1], c[2])
+	r := fmt.Sprintf("%d.%d.%d", c[2], c[1], c[0])
+
+	switch {
+	case s == r:
+		return s
+	case s < r:
+		return s + "+"
+	default:
+		return r + "-"
+	}
+}
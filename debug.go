@@ -0,0 +1,245 @@
+package bohm
+
+import (
+	"hash/fnv"
+	"image"
+	"image/color"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// DebugOptions controls the debug rendering of a tiled model.
+type DebugOptions struct {
+	// CellSize is the size of a cell in pixels, 16 if 0.
+	CellSize int
+
+	// Arrows marks the orientation of each tile with a half-headed arrow,
+	// which points up and barbs to the left in the tile's first
+	// orientation. Labels writes the tile name in each cell.
+	Arrows, Labels bool
+}
+
+var (
+	debugUndecided    = color.RGBA{0xc0, 0xc0, 0xc0, 0xff}
+	debugContradicted = color.RGBA{0xe0, 0x30, 0x30, 0xff}
+	debugInk          = color.RGBA{0x20, 0x20, 0x20, 0xff}
+)
+
+// DebugImage draws the model without tile images: a flat colour per tile,
+// with options for its orientation and name. Cells that have not
+// collapsed show how many tiles they still allow.
+func (tm *Tiled) DebugImage(opt DebugOptions) *image.RGBA {
+	S := opt.CellSize
+	if S == 0 {
+		S = 16
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, tm.FM.X*S, tm.FM.Y*S))
+	for x := 0; x < tm.FM.X; x++ {
+		for y := 0; y < tm.FM.Y; y++ {
+			cell := img.SubImage(image.Rect(x*S, y*S, (x+1)*S, (y+1)*S)).(*image.RGBA)
+
+			t, count := tm.collapsed(x, y)
+			if t < 0 {
+				bg := debugUndecided
+				if count == 0 {
+					bg = debugContradicted
+				}
+				fillRect(cell, cell.Rect, bg)
+
+				text := strconv.Itoa(count)
+				at := cell.Rect.Min.Add(image.Pt((S-textWidth(text))/2, (S-glyphHeight)/2))
+				drawText(cell, at, text, debugInk)
+				continue
+			}
+
+			fillRect(cell, cell.Rect, tileColor(tm.names[t]))
+			if opt.Arrows {
+				tm.drawArrow(cell, t)
+			}
+			if opt.Labels {
+				drawText(cell, cell.Rect.Min.Add(image.Pt(1, 1)), tm.tileRef(t), debugInk)
+			}
+		}
+	}
+	return img
+}
+
+// DebugText writes the model as a grid of tile references, one row of
+// cells per line. Cells that have not collapsed show "?" and the number of
+// tiles they still allow.
+func (tm *Tiled) DebugText() string {
+	cells := make([]string, 0, tm.FM.X*tm.FM.Y)
+	var width int
+	for y := 0; y < tm.FM.Y; y++ {
+		for x := 0; x < tm.FM.X; x++ {
+			s := "?"
+			if t, count := tm.collapsed(x, y); t >= 0 {
+				s = tm.tileRef(t)
+			} else {
+				s += strconv.Itoa(count)
+			}
+			if len(s) > width {
+				width = len(s)
+			}
+			cells = append(cells, s)
+		}
+	}
+
+	var b strings.Builder
+	for i, s := range cells {
+		b.WriteString(s)
+		if (i+1)%tm.FM.X == 0 {
+			b.WriteByte('\n')
+		} else {
+			b.WriteString(strings.Repeat(" ", width-len(s)+1))
+		}
+	}
+	return b.String()
+}
+
+// collapsed returns the only tile allowed in a cell, or -1 and the number
+// of tiles allowed.
+func (tm *Tiled) collapsed(x, y int) (t, count int) {
+	t = -1
+	for t2, on := range tm.wave[x][y] {
+		if on {
+			count++
+			t = t2
+		}
+	}
+	if count != 1 {
+		return -1, count
+	}
+	return t, 1
+}
+
+// tileColor picks a stable colour for a tile name.
+func tileColor(name string) color.RGBA {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	hue := float64(h.Sum32()%360) / 60
+
+	// a light colour of that hue, so that dark ink stays readable.
+	f := func(n float64) uint8 {
+		k := math.Mod(n+hue, 6)
+		v := 0.9 - 0.45*math.Max(0, math.Min(math.Min(k, 4-k), 1))
+		return uint8(v*0xff + 0.5)
+	}
+	return color.RGBA{f(5), f(3), f(1), 0xff}
+}
+
+// drawArrow draws the arrow of the tile's first orientation turned and
+// mirrored the way orientation t is.
+func (tm *Tiled) drawArrow(img *image.RGBA, t int) {
+	_, k := tm.orientation(t)
+
+	// the points are in units of the cell, y down, and follow CarTile: a
+	// quarter turn takes the right edge to the top.
+	orient := func(u, v float64) (float64, float64) {
+		for i := 0; i < k%4; i++ {
+			u, v = v, 1-u
+		}
+		if k >= 4 {
+			u = 1 - u
+		}
+		return u, v
+	}
+
+	S := float64(img.Rect.Dx())
+	line := func(u0, v0, u1, v1 float64) {
+		u0, v0 = orient(u0, v0)
+		u1, v1 = orient(u1, v1)
+
+		steps := int(S) * 2
+		for i := 0; i <= steps; i++ {
+			f := float64(i) / float64(steps)
+			x := int((u0 + (u1-u0)*f) * (S - 1))
+			y := int((v0 + (v1-v0)*f) * (S - 1))
+			img.SetRGBA(img.Rect.Min.X+x, img.Rect.Min.Y+y, debugInk)
+		}
+	}
+	line(0.5, 0.85, 0.5, 0.15)
+	line(0.5, 0.15, 0.25, 0.4)
+}
+
+func fillRect(img *image.RGBA, r image.Rectangle, c color.RGBA) {
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			img.SetRGBA(x, y, c)
+		}
+	}
+}
+
+const (
+	glyphWidth  = 3
+	glyphHeight = 5
+)
+
+func textWidth(s string) int { return len(s)*(glyphWidth+1) - 1 }
+
+// drawText writes s with the top left at at, clipped to img. Letters are
+// drawn in capitals and unknown characters as '?'.
+func drawText(img *image.RGBA, at image.Point, s string, c color.RGBA) {
+	for _, r := range strings.ToUpper(s) {
+		g, ok := font[r]
+		if !ok {
+			g = font['?']
+		}
+		for i, bit := range g {
+			p := at.Add(image.Pt(i%glyphWidth, i/glyphWidth))
+			if bit == '1' && p.In(img.Rect) {
+				img.SetRGBA(p.X, p.Y, c)
+			}
+		}
+		at.X += glyphWidth + 1
+	}
+}
+
+// font holds 3x5 glyphs, row by row from the top.
+var font = map[rune]string{
+	' ':  "000000000000000",
+	'!':  "010010010000010",
+	'-':  "000000111000000",
+	'.':  "000000000000010",
+	'?':  "111001010000010",
+	'_':  "000000000000111",
+	'\\': "100100010001001",
+	'0':  "111101101101111",
+	'1':  "010110010010111",
+	'2':  "111001111100111",
+	'3':  "111001111001111",
+	'4':  "101101111001001",
+	'5':  "111100111001111",
+	'6':  "111100111101111",
+	'7':  "111001001001001",
+	'8':  "111101111101111",
+	'9':  "111101111001111",
+	'A':  "010101111101101",
+	'B':  "110101110101110",
+	'C':  "011100100100011",
+	'D':  "110101101101110",
+	'E':  "111100110100111",
+	'F':  "111100110100100",
+	'G':  "011100101101011",
+	'H':  "101101111101101",
+	'I':  "111010010010111",
+	'J':  "001001001101010",
+	'K':  "101101110101101",
+	'L':  "100100100100111",
+	'M':  "101111111101101",
+	'N':  "110101101101101",
+	'O':  "010101101101010",
+	'P':  "110101110100100",
+	'Q':  "010101101110011",
+	'R':  "110101110101101",
+	'S':  "011100010001110",
+	'T':  "111010010010010",
+	'U':  "101101101101111",
+	'V':  "101101101101010",
+	'W':  "101101111111101",
+	'X':  "101101010101101",
+	'Y':  "101101010010010",
+	'Z':  "111001010100111",
+}
@@ -0,0 +1,72 @@
+package bohm
+
+import (
+	"image"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestTiledDebug(t *testing.T) {
+	// the tile images do not exist.
+	fsys := fstest.MapFS{
+		"proto/data.xml": &fstest.MapFile{Data: []byte(`<set size="8">
+	<tiles>
+		<tile name="road" symmetry="I" sockets="g r g r"/>
+		<tile name="grass" symmetry="X" sockets="g g g g"/>
+	</tiles>
+</set>`)},
+	}
+
+	tm, err := NewTiledFS(fsys, "proto", TiledOptions{Width: 5, Height: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tm.Run(testSeed, 3)
+	partial := tm.DebugText()
+	if !strings.Contains(partial, "?") {
+		t.Errorf("partial grid shows no undecided cells:\n%s", partial)
+	}
+
+	if !tm.Run(testSeed, 0) {
+		t.Fatal("CONTRADICTION")
+	}
+
+	// columns are as wide as the longest reference, "road 1".
+	var want strings.Builder
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 5; x++ {
+			tile, _ := tm.collapsed(x, y)
+			ref := tm.tileRef(tile)
+			if x < 4 {
+				ref += strings.Repeat(" ", 7-len(ref))
+			}
+			want.WriteString(ref)
+		}
+		want.WriteByte('\n')
+	}
+	if text := tm.DebugText(); text != want.String() {
+		t.Errorf("DebugText:\n%s\nwant:\n%s", text, want.String())
+	}
+
+	img := tm.DebugImage(DebugOptions{CellSize: 12, Arrows: true, Labels: true})
+	if img.Rect != image.Rect(0, 0, 60, 48) {
+		t.Fatalf("image bounds %v", img.Rect)
+	}
+
+	// the background of every cell is the colour of its tile; the arrow and
+	// label stay off the bottom right corner.
+	for x := 0; x < 5; x++ {
+		for y := 0; y < 4; y++ {
+			tile, _ := tm.collapsed(x, y)
+			name := tm.names[tile]
+			if got, want := img.RGBAAt(x*12+11, y*12+11), tileColor(name); got != want {
+				t.Errorf("cell (%d, %d) is %v, want %v", x, y, got, want)
+			}
+		}
+	}
+	if tileColor("road") == tileColor("grass") {
+		t.Error("road and grass have the same colour")
+	}
+}
@@ -0,0 +1,102 @@
+package bohm
+
+import (
+	"fmt"
+	"strings"
+)
+
+type Edge uint8
+
+const (
+	EdgeTop Edge = 1 << iota
+	EdgeRight
+	EdgeBottom
+	EdgeLeft
+
+	EdgeAll = EdgeTop | EdgeRight | EdgeBottom | EdgeLeft
+)
+
+// ParseEdges parses a space or comma separated list of edge names
+// (top, right, bottom, left or all).
+func ParseEdges(s string) (Edge, error) {
+	var e Edge
+	for _, f := range strings.FieldsFunc(s, isListSep) {
+		switch strings.ToLower(f) {
+		case "top":
+			e |= EdgeTop
+		case "right":
+			e |= EdgeRight
+		case "bottom":
+			e |= EdgeBottom
+		case "left":
+			e |= EdgeLeft
+		case "all":
+			e |= EdgeAll
+		default:
+			return 0, fmt.Errorf("bohm: unknown edge %q", f)
+		}
+	}
+	return e, nil
+}
+
+func isListSep(r rune) bool { return r == ' ' || r == ',' }
+
+// EdgeRule restricts the cells along Edges to the states in Allowed.
+// An Exclusive rule also bans Allowed from every cell off those edges.
+type EdgeRule struct {
+	Edges     Edge
+	Allowed   []int
+	Exclusive bool
+}
+
+// Pin adds an edge rule applied every time the model is cleared.
+func (m *Model) Pin(rule EdgeRule) {
+	m.edges = append(m.edges, rule)
+}
+
+// pinEdges applies the edge rules to the w by h region of the wave whose
+// cells are not on the boundary. It reports whether any state was banned.
+func (m *Model) pinEdges(w, h int) bool {
+	var change bool
+	for _, rule := range m.edges {
+		if m.pinRule(rule, w, h) {
+			change = true
+		}
+	}
+	return change
+}
+
+// pinRule applies a single edge rule to the w by h region of the wave.
+func (m *Model) pinRule(rule EdgeRule, w, h int) bool {
+	allowed := make([]bool, len(m.stationary))
+	for _, t := range rule.Allowed {
+		if t >= 0 && t < len(allowed) {
+			allowed[t] = true
+		}
+	}
+
+	var change bool
+	for x := 0; x < w; x++ {
+		for y := 0; y < h; y++ {
+			edge := (rule.Edges&EdgeTop != 0 && y == 0) ||
+				(rule.Edges&EdgeRight != 0 && x == w-1) ||
+				(rule.Edges&EdgeBottom != 0 && y == h-1) ||
+				(rule.Edges&EdgeLeft != 0 && x == 0)
+
+			if !edge && !rule.Exclusive {
+				continue
+			}
+
+			for t, on := range m.wave[x][y] {
+				// on an edge only allowed states survive, elsewhere
+				// an exclusive rule bans them.
+				if on && allowed[t] != edge {
+					m.wave[x][y][t] = false
+					m.changes[x][y] = true
+					change = true
+				}
+			}
+		}
+	}
+	return change
+}
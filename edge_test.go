@@ -0,0 +1,166 @@
+package bohm
+
+import (
+	"image"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestParseEdges(t *testing.T) {
+	for _, tc := range []struct {
+		s    string
+		want Edge
+	}{
+		{"", 0},
+		{"top", EdgeTop},
+		{"Left right", EdgeLeft | EdgeRight},
+		{"bottom,top", EdgeBottom | EdgeTop},
+		{"all", EdgeAll},
+		{"left, all", EdgeAll},
+	} {
+		got, err := ParseEdges(tc.s)
+		if err != nil {
+			t.Errorf("ParseEdges(%q): %v", tc.s, err)
+		} else if got != tc.want {
+			t.Errorf("ParseEdges(%q) = %04b, want %04b", tc.s, got, tc.want)
+		}
+	}
+
+	if _, err := ParseEdges("top middle"); err == nil {
+		t.Error("ParseEdges accepts an unknown edge")
+	}
+}
+
+// layout returns, for every cell of m, whether state t is still allowed.
+func layout(m *Model, t int) [][]bool {
+	cells := make([][]bool, len(m.wave[0]))
+	for y := range cells {
+		cells[y] = make([]bool, len(m.wave))
+		for x := range m.wave {
+			cells[y][x] = m.wave[x][y][t]
+		}
+	}
+	return cells
+}
+
+func TestPinRule(t *testing.T) {
+	newModel := func() *Model {
+		m := &Model{stationary: []float64{1, 1, 1}}
+		m.wave = make([][][]bool, 4)
+		m.changes = make([][]bool, 4)
+		for x := range m.wave {
+			m.wave[x] = make([][]bool, 3)
+			m.changes[x] = make([]bool, 3)
+			for y := range m.wave[x] {
+				m.wave[x][y] = []bool{true, true, true}
+			}
+		}
+		return m
+	}
+
+	// only state 1 survives on the left and bottom edges of the 3 by 2
+	// region; the exclusive rule also bans it everywhere else.
+	m := newModel()
+	m.Pin(EdgeRule{Edges: EdgeLeft | EdgeBottom, Allowed: []int{1}})
+	if !m.pinEdges(3, 2) {
+		t.Error("pinEdges reports no change")
+	}
+	if got, want := layout(m, 0), [][]bool{
+		{false, true, true, true},
+		{false, false, false, true},
+		{true, true, true, true},
+	}; !reflect.DeepEqual(got, want) {
+		t.Errorf("state 0 after an edge rule: %v, want %v", got, want)
+	}
+
+	m = newModel()
+	m.Pin(EdgeRule{Edges: EdgeLeft | EdgeBottom, Allowed: []int{1}, Exclusive: true})
+	m.pinEdges(3, 2)
+	if got, want := layout(m, 1), [][]bool{
+		{true, false, false, true},
+		{true, true, true, true},
+		{true, true, true, true},
+	}; !reflect.DeepEqual(got, want) {
+		t.Errorf("state 1 after an exclusive rule: %v, want %v", got, want)
+	}
+	if got, want := layout(m, 2), [][]bool{
+		{false, true, true, true},
+		{false, false, false, true},
+		{true, true, true, true},
+	}; !reflect.DeepEqual(got, want) {
+		t.Errorf("state 2 after an exclusive rule: %v, want %v", got, want)
+	}
+}
+
+func TestOverlappingGround(t *testing.T) {
+	// sky above a single row of earth.
+	sample := image.NewRGBA(image.Rect(0, 0, 4, 3))
+	for x := 0; x < 4; x++ {
+		sample.Set(x, 0, testWhite)
+		sample.Set(x, 1, testWhite)
+		sample.Set(x, 2, testBlack)
+	}
+
+	om, err := NewOverlappingImages([]OverlappingSample{{Image: sample, Periodic: Periodicity{true, true}}}, OverlappingOptions{
+		N:        2,
+		Width:    6,
+		Height:   5,
+		Symmetry: SymIdentity,
+		Ground:   1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := om.patterns[1], []byte{0, 0, 1, 1}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("pattern 1 is %v, want sky above earth %v", got, want)
+	}
+	om.Clear()
+
+	// the ground pattern fills the last row of the whole output and
+	// nothing else, although that row is on the boundary of an output that
+	// does not wrap.
+	for y := 0; y < 5; y++ {
+		for x := 0; x < 6; x++ {
+			row := om.wave[x][y]
+			if row[1] != (y == 4) {
+				t.Errorf("cell (%d, %d) allows ground: %v", x, y, row[1])
+			}
+			if y == 4 && (row[0] || row[2]) {
+				t.Errorf("cell (%d, %d) is %v, want only ground", x, y, row)
+			}
+		}
+	}
+	if !om.Run(testSeed, 0) {
+		t.Error("CONTRADICTION")
+	}
+}
+
+func TestOverlappingIndices(t *testing.T) {
+	om, err := NewOverlappingImages([]OverlappingSample{{Image: stripes(6, 3, testRed), Periodic: Periodicity{true, true}}}, OverlappingOptions{
+		N:        2,
+		Width:    8,
+		Height:   8,
+		Symmetry: SymIdentity,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := om.Indices("0, 1 -1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{0, 1, om.T - 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Indices = %v, want %v", got, want)
+	}
+
+	for _, spec := range []string{"x", "0,nine", "-100"} {
+		if _, err := om.Indices(spec); err == nil {
+			t.Errorf("Indices(%q) accepted", spec)
+		}
+	}
+	if _, err := om.Indices(strconv.Itoa(om.T)); err == nil {
+		t.Errorf("Indices accepts pattern %d of %d", om.T, om.T)
+	}
+}
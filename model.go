@@ -2,6 +2,7 @@ package bohm
 
 import (
 	"image"
+	"image/draw"
 	"math"
 	"math/rand"
 )
@@ -10,6 +11,11 @@ type Point struct {
 	X, Y int
 }
 
+// Periodicity selects the axes along which a sample or output wraps.
+type Periodicity struct {
+	X, Y bool
+}
+
 type Model struct {
 	wave       [][][]bool
 	changes    [][]bool
@@ -21,6 +27,10 @@ type Model struct {
 
 	logProb []float64
 
+	edges []EdgeRule
+
+	Blend Blend
+
 	ModelDep
 }
 
@@ -151,6 +161,10 @@ func (m *Model) Observe(logT float64, logProb []float64) observeState {
 		}
 	}
 
+	if mod, ok := m.ModelDep.(modulator); ok {
+		mod.modulate(argminx, argminy, m.distribution)
+	}
+
 	r := randIndex(m.distribution, m.random.Float64())
 
 	for t := range m.wave[argminx][argminy] {
@@ -184,9 +198,22 @@ func randIndex(a []float64, r float64) int {
 	return 0
 }
 
+// modulator is implemented by models that weigh the states of a cell by
+// the states already collapsed around it.
+type modulator interface {
+	modulate(x, y int, distribution []float64)
+}
+
 type ModelDep interface {
 	Clear()
 	Graphics() (image.Image, error)
+
+	// GraphicsInto draws the pixels of the output inside r into dst, each
+	// magnified to a scale by scale block, with r.Min at the top left of dst.
+	// Scratch space is kept by the model, so repeated calls allocate little,
+	// but neither it nor Graphics may be called concurrently on one model.
+	GraphicsInto(dst draw.Image, r image.Rectangle, scale int) error
+
 	OnBoundary(x, y int) bool
 	Propagate() bool
 }
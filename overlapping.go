@@ -1,73 +1,181 @@
 package bohm
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
 	"image"
 	"image/color"
+	"image/draw"
 	_ "image/png"
-	"os"
-	"path/filepath"
+	"io/fs"
+	"strconv"
+	"strings"
 )
 
 type Overlapping struct {
-	propagator [][][][]int
+	propagator *propagator
 	N          int
 
 	patterns [][]byte
 	colors   []color.Color
-	ground   int
+
+	// ignore is the palette index of ignoreColor, or -1.
+	ignore      int
+	ignoreColor color.Color
+
+	// ground is the pattern pinned to the bottom row, or 0 for none.
+	ground int
+
+	// scratch space of GraphicsInto: the palette as RGBA, and a weight
+	// per colour.
+	palette []color.RGBA
+	weights []float64
 
 	//
 	T        int
 	FM       Point
-	periodic bool
+	periodic Periodicity
 
 	Model
 }
 
-func NewOverlapping(path, name string, N, width, height int, periodicInput, periodicOutput bool, symmetry, ground int) *Overlapping {
-	om := &Overlapping{
-		N:        N,
-		periodic: periodicOutput,
-		ground:   ground,
-		FM:       Point{X: width, Y: height},
+// OverlappingSample is one source image of an overlapping model. Image
+// may be left nil by the path and fs.FS constructors, which then load it
+// from Name+".png".
+type OverlappingSample struct {
+	Name     string
+	Image    image.Image
+	Weight   float64
+	Periodic Periodicity
+}
+
+type OverlappingOptions struct {
+	N             int
+	Width, Height int
+	Periodic      Periodicity
+	Symmetry      Symmetry
+
+	// Ground pins a pattern, counted back from the last if negative, to
+	// the last row of the output and bans it from every other row. Unlike
+	// edge rules it covers the whole output, even where a non-periodic
+	// output has boundary cells.
+	Ground int
+
+	// Ignore marks sample pixels of this colour as not meant to be
+	// learned. IgnoreMode selects how patterns containing them are used.
+	Ignore     color.Color
+	IgnoreMode IgnoreMode
+}
+
+type IgnoreMode int
+
+const (
+	// IgnoreSkip drops every pattern containing an ignored pixel.
+	IgnoreSkip IgnoreMode = iota
+
+	// IgnoreWildcard keeps such patterns, with ignored pixels agreeing
+	// with any colour.
+	IgnoreWildcard
+)
+
+func ParseIgnoreMode(s string) (IgnoreMode, error) {
+	switch strings.ToLower(s) {
+	case "", "skip":
+		return IgnoreSkip, nil
+	case "wildcard":
+		return IgnoreWildcard, nil
+	}
+	return 0, fmt.Errorf("bohm: unknown ignore mode %q", s)
+}
+
+// ParseColor parses "transparent" or a "#rrggbb" or "#rrggbbaa" hex colour.
+func ParseColor(s string) (color.Color, error) {
+	if strings.EqualFold(s, "transparent") {
+		return color.NRGBA{}, nil
 	}
 
-	om.Model = NewModel(om)
+	hex := strings.TrimPrefix(s, "#")
+	if len(hex) == 6 {
+		hex += "ff"
+	}
+
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil || len(hex) != 8 {
+		return nil, fmt.Errorf("bohm: bad colour %q", s)
+	}
+	return color.NRGBA{R: uint8(v >> 24), G: uint8(v >> 16), B: uint8(v >> 8), A: uint8(v)}, nil
+}
+
+func NewOverlapping(path, name string, N, width, height int, periodicInput, periodicOutput bool, symmetry, ground int) *Overlapping {
+	samples := []OverlappingSample{{
+		Name:     name,
+		Periodic: Periodicity{periodicInput, periodicInput},
+	}}
 
-	bitmap, err := openBMP(filepath.Join(path, name+".png"))
+	om, err := NewOverlappingSamples(path, samples, OverlappingOptions{
+		N:        N,
+		Width:    width,
+		Height:   height,
+		Periodic: Periodicity{periodicOutput, periodicOutput},
+		Symmetry: SymmetryFirst(symmetry),
+		Ground:   ground,
+	})
 	if err != nil {
 		panic(err)
 	}
+	return om
+}
+
+func NewOverlappingSamples(path string, samples []OverlappingSample, opt OverlappingOptions) (*Overlapping, error) {
+	return NewOverlappingFS(osDir(path), samples, opt)
+}
 
-	var SMX, SMY int
-	{
-		rect := bitmap.Bounds()
-		SMX = rect.Max.X
-		SMY = rect.Max.Y
+func NewOverlappingFS(fsys fs.FS, samples []OverlappingSample, opt OverlappingOptions) (*Overlapping, error) {
+	samples = append([]OverlappingSample(nil), samples...)
+	for i, s := range samples {
+		if s.Image != nil {
+			continue
+		}
+
+		img, err := openBMP(fsys, s.Name+".png")
+		if err != nil {
+			return nil, err
+		}
+		samples[i].Image = img
 	}
-	// byte[,] sample = new byte[SMX, SMY];
-	sample := make([][]byte, SMX)
-	for x := range sample {
-		sample[x] = make([]byte, SMY)
+
+	return NewOverlappingImages(samples, opt)
+}
+
+func NewOverlappingImages(samples []OverlappingSample, opt OverlappingOptions) (*Overlapping, error) {
+	if len(samples) == 0 {
+		return nil, errors.New("bohm: no overlapping samples")
 	}
 
-	// var colors []color.Color
-	for y := 0; y < SMY; y++ {
-		for x := 0; x < SMX; x++ {
-			color := bitmap.At(x, y)
+	N := opt.N
+	om := &Overlapping{
+		N:        N,
+		periodic: opt.Periodic,
+		FM:       Point{X: opt.Width, Y: opt.Height},
+		ignore:   -1,
 
-			var i int
-			for _, c := range om.colors {
-				if c == color {
-					break
-				}
-				i++
-			}
+		ignoreColor: opt.Ignore,
+	}
 
-			if i == len(om.colors) {
-				om.colors = append(om.colors, color)
-			}
-			sample[x][y] = byte(i)
+	om.Model = NewModel(om)
+
+	// the palette is shared by every sample so patterns from different
+	// images agree on colour indices.
+	bitmaps := make([][][]byte, len(samples))
+	for i, s := range samples {
+		if s.Image == nil {
+			return nil, fmt.Errorf("bohm: sample %q has no image", s.Name)
+		}
+
+		var err error
+		if bitmaps[i], err = om.indexSample(s.Image); err != nil {
+			return nil, fmt.Errorf("bohm: sample %q: %v", s.Name, err)
 		}
 	}
 
@@ -84,12 +192,6 @@ func NewOverlapping(path, name string, N, width, height int, periodicInput, peri
 		return result
 	}
 
-	patternFromSample := func(x, y int) []byte {
-		return pattern(func(dx, dy int) byte {
-			return sample[(x+dx)%SMX][(y+dy)%SMY]
-		})
-	}
-
 	rotate := func(p []byte) []byte {
 		return pattern(func(x, y int) byte {
 			return p[N-1-y+x*N]
@@ -131,44 +233,77 @@ func NewOverlapping(path, name string, N, width, height int, periodicInput, peri
 		return result
 	}
 
+	symmetry := opt.Symmetry
+	if symmetry == 0 {
+		symmetry = SymIdentity
+	}
+
 	// Dictionary<int, int> weights = new Dictionary<int, int>();
-	weights := make(map[int]int)
+	weights := make(map[int]float64)
 	var ordering []int
-	for y := 0; (periodicInput && y < SMY) || (!periodicInput && y < SMY-N+1); y++ {
-		for x := 0; (periodicInput && x < SMX) || (!periodicInput && x < SMX-N+1); x++ {
-			var ps [8][]byte
-
-			ps[0] = patternFromSample(x, y)
-			ps[1] = reflect(ps[0])
-			ps[2] = rotate(ps[0])
-			ps[3] = reflect(ps[2])
-			ps[4] = rotate(ps[2])
-			ps[5] = reflect(ps[4])
-			ps[6] = rotate(ps[4])
-			ps[7] = reflect(ps[6])
-
-			for k := 0; k < symmetry; k++ {
-				ind := index(ps[k])
-				if _, ok := weights[ind]; ok {
-					weights[ind]++
-				} else {
-					weights[ind] = 1
-					ordering = append(ordering, ind)
+	for i, sample := range bitmaps {
+		SMX, SMY := len(sample), len(sample[0])
+		periodicInput := samples[i].Periodic
+
+		weight := samples[i].Weight
+		if weight < 0 {
+			return nil, fmt.Errorf("bohm: sample %q: negative weight %v", samples[i].Name, weight)
+		}
+		if weight == 0 {
+			weight = 1
+		}
+
+		patternFromSample := func(x, y int) []byte {
+			return pattern(func(dx, dy int) byte {
+				return sample[(x+dx)%SMX][(y+dy)%SMY]
+			})
+		}
+
+		for y := 0; (periodicInput.Y && y < SMY) || (!periodicInput.Y && y < SMY-N+1); y++ {
+			for x := 0; (periodicInput.X && x < SMX) || (!periodicInput.X && x < SMX-N+1); x++ {
+				var ps [8][]byte
+
+				ps[0] = patternFromSample(x, y)
+				if opt.IgnoreMode == IgnoreSkip && om.ignore >= 0 && bytes.IndexByte(ps[0], byte(om.ignore)) >= 0 {
+					continue
+				}
+				ps[1] = reflect(ps[0])
+				ps[2] = rotate(ps[0])
+				ps[3] = reflect(ps[2])
+				ps[4] = rotate(ps[2])
+				ps[5] = reflect(ps[4])
+				ps[6] = rotate(ps[4])
+				ps[7] = reflect(ps[6])
+
+				for k := range ps {
+					if symmetry&(1<<uint(k)) == 0 {
+						continue
+					}
+
+					ind := index(ps[k])
+					if _, ok := weights[ind]; !ok {
+						ordering = append(ordering, ind)
+					}
+					weights[ind] += weight
 				}
 			}
 		}
 	}
 
+	if len(weights) == 0 {
+		return nil, errors.New("bohm: no patterns learned from samples")
+	}
+
 	om.T = len(weights)
-	om.ground = (om.ground + om.T) % om.T
+
+	om.ground = (opt.Ground + om.T) % om.T
 
 	om.patterns = make([][]byte, om.T)
 	om.stationary = make([]float64, om.T)
-	om.propagator = make([][][][]int, om.T)
 
 	for i, w := range ordering {
 		om.patterns[i] = patternFromIndex(w)
-		om.stationary[i] = float64(weights[w])
+		om.stationary[i] = weights[w]
 	}
 
 	om.wave = make([][][]bool, om.FM.X)
@@ -203,7 +338,8 @@ func NewOverlapping(path, name string, N, width, height int, periodicInput, peri
 
 		for y := ymin; y < ymax; y++ {
 			for x := xmin; x < xmax; x++ {
-				if p1[x+N*y] != p2[x-dx+N*(y-dy)] {
+				c1, c2 := p1[x+N*y], p2[x-dx+N*(y-dy)]
+				if c1 != c2 && int(c1) != om.ignore && int(c2) != om.ignore {
 					return false
 				}
 			}
@@ -211,42 +347,98 @@ func NewOverlapping(path, name string, N, width, height int, periodicInput, peri
 		return true
 	}
 
-	for t := 0; t < om.T; t++ {
-		om.propagator[t] = make([][][]int, 2*N-1)
-		for x := 0; x < 2*N-1; x++ {
-			om.propagator[t][x] = make([][]int, 2*N-1)
-			for y := 0; y < 2*N-1; y++ {
-				var list []int
-				for t2 := 0; t2 < om.T; t2++ {
-					if agrees(om.patterns[t], om.patterns[t2], x-N+1, y-N+1) {
-						list = append(list, t2)
-					}
+	om.propagator = newPropagator(om.patterns, N, agrees)
+
+	return om, nil
+}
+
+// indexSample converts bitmap into a grid of palette indices, extending
+// the palette with any colours not seen in previous samples.
+func (om *Overlapping) indexSample(bitmap image.Image) ([][]byte, error) {
+	rect := bitmap.Bounds()
+	SMX, SMY := rect.Dx(), rect.Dy()
+	if SMX == 0 || SMY == 0 {
+		return nil, errors.New("empty image")
+	}
+
+	// byte[,] sample = new byte[SMX, SMY];
+	sample := make([][]byte, SMX)
+	for x := range sample {
+		sample[x] = make([]byte, SMY)
+	}
+
+	for y := 0; y < SMY; y++ {
+		for x := 0; x < SMX; x++ {
+			color := bitmap.At(rect.Min.X+x, rect.Min.Y+y)
+
+			// every pixel matching the ignored colour shares one index,
+			// whatever its colour model.
+			ignored := om.isIgnored(color)
+			if ignored && om.ignore >= 0 {
+				sample[x][y] = byte(om.ignore)
+				continue
+			}
+
+			var i int
+			for _, c := range om.colors {
+				if c == color {
+					break
 				}
-				om.propagator[t][x][y] = make([]int, len(list))
-				for c := 0; c < len(list); c++ {
-					om.propagator[t][x][y][c] = list[c]
+				i++
+			}
+
+			if i == len(om.colors) {
+				if i > 0xff {
+					return nil, errors.New("more than 256 colours")
+				}
+				om.colors = append(om.colors, color)
+				if ignored {
+					om.ignore = i
 				}
 			}
+			sample[x][y] = byte(i)
 		}
 	}
 
-	return om
+	return sample, nil
+}
+
+func (om *Overlapping) isIgnored(c color.Color) bool {
+	if om.ignoreColor == nil {
+		return false
+	}
+	r0, g0, b0, a0 := om.ignoreColor.RGBA()
+	r1, g1, b1, a1 := c.RGBA()
+	return r0 == r1 && g0 == g1 && b0 == b1 && a0 == a1
 }
 
 func (om *Overlapping) OnBoundary(x, y int) bool {
-	return !om.periodic && (x+om.N > om.FM.X || y+om.N > om.FM.Y)
+	return (!om.periodic.X && x+om.N > om.FM.X) || (!om.periodic.Y && y+om.N > om.FM.Y)
 }
 
 func (om *Overlapping) Propagate() bool {
 	change := false
-	var b bool
 	var x2, y2, sx, sy int
 	var allowed []bool
 
+	prop := om.propagator
+	current := make([]uint64, prop.words)
+	union := make([]uint64, prop.words)
+
 	for x1 := 0; x1 < om.FM.X; x1++ {
 		for y1 := 0; y1 < om.FM.Y; y1++ {
 			if om.changes[x1][y1] {
 				om.changes[x1][y1] = false
+
+				for i := range current {
+					current[i] = 0
+				}
+				for t1, on := range om.wave[x1][y1] {
+					if on {
+						current[t1/64] |= 1 << uint(t1%64)
+					}
+				}
+
 				for dx := -om.N + 1; dx < om.N; dx++ {
 					for dy := -om.N + 1; dy < om.N; dy++ {
 						x2 = x1 + dx
@@ -266,20 +458,41 @@ func (om *Overlapping) Propagate() bool {
 							sy -= om.FM.Y
 						}
 
-						if !om.periodic && (sx+om.N > om.FM.X || sy+om.N > om.FM.Y) {
+						if om.OnBoundary(sx, sy) {
 							continue
 						}
 						allowed = om.wave[sx][sy]
 
+						// t2 survives if some pattern still allowed at
+						// (x1, y1) agrees with it. For stored offsets that
+						// is the union of their sets, otherwise the set of
+						// t2 at the opposite offset meets the current cell.
+						k, ok := prop.stored(dx, dy)
+						if ok {
+							for i := range union {
+								union[i] = 0
+							}
+							forBits(current, func(t1 int) {
+								for i, w := range prop.set(t1, k) {
+									union[i] |= w
+								}
+							})
+						} else {
+							k, _ = prop.stored(-dx, -dy)
+						}
+
 						for t2 := 0; t2 < om.T; t2++ {
 							if !allowed[t2] {
 								continue
 							}
-							b = false
-							prop := om.propagator[t2][om.N-1-dx][om.N-1-dy]
-							for i1 := 0; i1 < len(prop) && !b; i1++ {
-								b = om.wave[x1][y1][prop[i1]]
+
+							var b bool
+							if ok {
+								b = hasBit(union, t2)
+							} else {
+								b = intersects(prop.set(t2, k), current)
 							}
+
 							if !b {
 								om.changes[sx][sy] = true
 								change = true
@@ -296,75 +509,159 @@ func (om *Overlapping) Propagate() bool {
 
 func (om *Overlapping) Graphics() (image.Image, error) {
 	result := image.NewRGBA(image.Rect(0, 0, om.FM.X, om.FM.Y))
+	if err := om.GraphicsInto(result, result.Bounds(), 1); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// GraphicsInto draws a viewport of the output as ModelDep describes.
+func (om *Overlapping) GraphicsInto(dst draw.Image, r image.Rectangle, scale int) error {
+	v, err := newViewport(dst, r, image.Rect(0, 0, om.FM.X, om.FM.Y), scale)
+	if err != nil {
+		return err
+	}
+
+	if len(om.palette) != len(om.colors) {
+		om.palette = make([]color.RGBA, len(om.colors))
+		for i, c := range om.colors {
+			om.palette[i] = color.RGBAModel.Convert(c).(color.RGBA)
+		}
+		om.weights = make([]float64, len(om.colors))
+	}
+
+	if om.Blend == BlendMostProbable {
+		for y := v.r.Min.Y; y < v.r.Max.Y; y++ {
+			for x := v.r.Min.X; x < v.r.Max.X; x++ {
+				v.set(x, y, om.palette[om.mostProbable(x, y, om.weights)])
+			}
+		}
+		return nil
+	}
+
+	bl := blender{linear: om.Blend == BlendLinear}
+	for y := v.r.Min.Y; y < v.r.Max.Y; y++ {
+		for x := v.r.Min.X; x < v.r.Max.X; x++ {
+			bl.reset()
+			om.contribute(x, y, func(c byte, _ int) {
+				r, g, b, a := om.colors[c].RGBA()
+				bl.add(r, g, b, a, 1)
+			})
+			v.set(x, y, bl.rgba())
+		}
+	}
+	return nil
+}
+
+// Paletted renders the output using the exact sample palette. Pixels
+// still covered by several patterns take their most probable colour, so a
+// fully collapsed output round-trips losslessly.
+func (om *Overlapping) Paletted() (*image.Paletted, error) {
+	result := image.NewPaletted(image.Rect(0, 0, om.FM.X, om.FM.Y), om.colors)
+
+	weights := make([]float64, len(om.colors))
 	for y := 0; y < om.FM.Y; y++ {
 		for x := 0; x < om.FM.X; x++ {
-			var contributors []byte
-			for dy := 0; dy < om.N; dy++ {
-				for dx := 0; dx < om.N; dx++ {
-					sx := x - dx
-					if sx < 0 {
-						sx += om.FM.X
-					}
+			result.SetColorIndex(x, y, om.mostProbable(x, y, weights))
+		}
+	}
+	return result, nil
+}
 
-					sy := y - dy
-					if sy < 0 {
-						sy += om.FM.Y
-					}
+// contribute calls fn with the colour each pattern still allowed around
+// (x, y) gives that pixel, along with the pattern itself. Ignored pixels
+// say nothing about the output and are left out.
+func (om *Overlapping) contribute(x, y int, fn func(c byte, t int)) {
+	for dy := 0; dy < om.N; dy++ {
+		for dx := 0; dx < om.N; dx++ {
+			sx := x - dx
+			if sx < 0 {
+				sx += om.FM.X
+			}
 
-					if om.OnBoundary(sx, sy) {
-						continue
-					}
+			sy := y - dy
+			if sy < 0 {
+				sy += om.FM.Y
+			}
 
-					for t, on := range om.wave[sx][sy] {
-						if on {
-							contributors = append(contributors, om.patterns[t][dx+dy*om.N])
-						}
-					}
-				}
+			if om.OnBoundary(sx, sy) {
+				continue
 			}
 
-			var r, g, b, a uint32
-			for _, c := range contributors {
-				r_, g_, b_, a_ := om.colors[c].RGBA()
-				r += r_
-				g += g_
-				b += b_
-				a += a_
+			for t, on := range om.wave[sx][sy] {
+				if c := om.patterns[t][dx+dy*om.N]; on && int(c) != om.ignore {
+					fn(c, t)
+				}
 			}
+		}
+	}
+}
 
-			lambda := 1.0 / float64(len(contributors))
-			result.SetRGBA(x, y, color.RGBA{
-				R: uint8(lambda * float64(r)),
-				G: uint8(lambda * float64(g)),
-				B: uint8(lambda * float64(b)),
-				A: uint8(lambda * float64(a)),
-			})
+// mostProbable returns the palette index with the greatest total pattern
+// weight at (x, y). weights is scratch space with one entry per colour.
+func (om *Overlapping) mostProbable(x, y int, weights []float64) byte {
+	for i := range weights {
+		weights[i] = 0
+	}
+
+	om.contribute(x, y, func(c byte, t int) {
+		weights[c] += om.stationary[t]
+	})
+
+	var best byte
+	for c, w := range weights {
+		if w > weights[best] {
+			best = byte(c)
 		}
 	}
-	return result, nil
+
+	if weights[best] == 0 && om.ignore >= 0 {
+		return byte(om.ignore)
+	}
+	return best
 }
 
 func (om *Overlapping) Clear() {
 	om.Model.Clear()
 
+	w, h := om.FM.X, om.FM.Y
+	if !om.periodic.X {
+		w -= om.N - 1
+	}
+	if !om.periodic.Y {
+		h -= om.N - 1
+	}
+
+	edges := om.pinEdges(w, h)
+
+	// as it always has, ground fills the last row of the whole output and
+	// is banned from every other row, even if the output is not periodic.
 	if om.ground != 0 {
-		for x := 0; x < om.FM.X; x++ {
-			for t := 0; t < om.T; t++ {
-				if t != om.ground {
-					om.wave[x][om.FM.Y-1][t] = false
-				}
-			}
-			om.changes[x][om.FM.Y-1] = true
+		rule := EdgeRule{Edges: EdgeBottom, Allowed: []int{om.ground}, Exclusive: true}
+		edges = om.pinRule(rule, om.FM.X, om.FM.Y) || edges
+	}
 
-			for y := 0; y < om.FM.Y-1; y++ {
-				om.wave[x][y][om.ground] = false
-				om.changes[x][y] = true
-			}
+	if edges {
+		for om.Propagate() {
+		}
+	}
+}
 
-			for om.Propagate() {
-			}
+// Indices resolves a comma separated list of pattern indices for use in an
+// EdgeRule. Negative indices count back from the last pattern.
+func (om *Overlapping) Indices(spec string) ([]int, error) {
+	var list []int
+	for _, f := range strings.FieldsFunc(spec, isListSep) {
+		t, err := strconv.Atoi(f)
+		if err != nil {
+			return nil, err
+		}
+		if t < -om.T || t >= om.T {
+			return nil, fmt.Errorf("bohm: pattern %d out of range [0, %d)", t, om.T)
 		}
+		list = append(list, (t+om.T)%om.T)
 	}
+	return list, nil
 }
 
 func power(a, n int) int {
@@ -375,8 +672,8 @@ func power(a, n int) int {
 	return product
 }
 
-func openBMP(name string) (image.Image, error) {
-	f, err := os.Open(name)
+func openBMP(fsys fs.FS, name string) (image.Image, error) {
+	f, err := fsys.Open(name)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,452 @@
+package bohm
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var (
+	testWhite = color.RGBA{0xff, 0xff, 0xff, 0xff}
+	testBlack = color.RGBA{0x00, 0x00, 0x00, 0xff}
+	testRed   = color.RGBA{0xff, 0x00, 0x00, 0xff}
+)
+
+// stripes returns a size by size image with a line of c every period
+// pixels on a white background.
+func stripes(size, period int, c color.Color) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			if x%period == 0 {
+				img.Set(x, y, c)
+			} else {
+				img.Set(x, y, testWhite)
+			}
+		}
+	}
+	return img
+}
+
+func TestOverlappingImages(t *testing.T) {
+	samples := []OverlappingSample{
+		{Name: "black", Image: stripes(8, 4, testBlack), Periodic: Periodicity{true, true}},
+		{Name: "red", Image: stripes(8, 4, testRed), Weight: 2, Periodic: Periodicity{true, true}},
+	}
+
+	om, err := NewOverlappingImages(samples, OverlappingOptions{
+		N:        2,
+		Width:    16,
+		Height:   16,
+		Periodic: Periodicity{true, true},
+		Symmetry: SymIdentity,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// white is shared between the samples.
+	if len(om.colors) != 3 {
+		t.Errorf("palette has %d colours, want 3", len(om.colors))
+	}
+
+	var black, red float64
+	for i, p := range om.patterns {
+		for _, c := range p {
+			switch om.colors[c] {
+			case testBlack:
+				black = om.stationary[i]
+			case testRed:
+				red = om.stationary[i]
+			}
+		}
+	}
+	if red != 2*black {
+		t.Errorf("red pattern weight = %v, want twice black (%v)", red, black)
+	}
+
+	if !om.Run(testSeed, 0) {
+		t.Fatal("CONTRADICTION")
+	}
+}
+
+func TestOverlappingSampleWeights(t *testing.T) {
+	samples := []OverlappingSample{
+		{Name: "black", Image: stripes(8, 4, testBlack), Periodic: Periodicity{true, true}},
+		{Name: "red", Image: stripes(8, 4, testRed), Weight: 3, Periodic: Periodicity{true, true}},
+	}
+	opt := OverlappingOptions{N: 2, Width: 8, Height: 8, Symmetry: SymIdentity}
+
+	om, err := NewOverlappingImages(samples, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the all white pattern occurs twice as often as either pattern with a
+	// stripe, in both samples, so it sums the weights of both.
+	var white, black, red float64
+	for i, p := range om.patterns {
+		switch {
+		case bytes.IndexByte(p, byte(colorIndex(om, testBlack))) >= 0:
+			black = om.stationary[i]
+		case bytes.IndexByte(p, byte(colorIndex(om, testRed))) >= 0:
+			red = om.stationary[i]
+		default:
+			white = om.stationary[i]
+		}
+	}
+	if red != 3*black || white != 2*(black+red) {
+		t.Errorf("weights: white %v, black %v, red %v; want red 3 times black and white twice their sum", white, black, red)
+	}
+
+	samples[1].Weight = -1
+	if _, err := NewOverlappingImages(samples, opt); err == nil {
+		t.Error("negative sample weight accepted")
+	}
+}
+
+// colorIndex returns the palette index of c in om, or -1.
+func colorIndex(om *Overlapping, c color.Color) int {
+	for i, pc := range om.colors {
+		if pc == c {
+			return i
+		}
+	}
+	return -1
+}
+
+func TestOverlappingMixedPeriodicity(t *testing.T) {
+	// a 4 by 3 sample of distinct colours has one pattern per position.
+	sample := image.NewRGBA(image.Rect(0, 0, 4, 3))
+	for y := 0; y < 3; y++ {
+		for x := 0; x < 4; x++ {
+			sample.Set(x, y, color.RGBA{uint8(x * 60), uint8(y * 60), 0, 0xff})
+		}
+	}
+	for _, tc := range []struct {
+		periodic Periodicity
+		want     int
+	}{
+		{Periodicity{false, false}, 3 * 2},
+		{Periodicity{true, false}, 4 * 2},
+		{Periodicity{false, true}, 3 * 3},
+		{Periodicity{true, true}, 4 * 3},
+	} {
+		om, err := NewOverlappingImages([]OverlappingSample{{Image: sample, Periodic: tc.periodic}}, OverlappingOptions{
+			N:        2,
+			Width:    4,
+			Height:   4,
+			Symmetry: SymIdentity,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if om.T != tc.want {
+			t.Errorf("input periodic %v: %d patterns, want %d", tc.periodic, om.T, tc.want)
+		}
+	}
+
+	// a checkerboard fits an even length, but cannot wrap around an odd
+	// one.
+	checker := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	checker.Set(0, 0, testBlack)
+	checker.Set(1, 0, testWhite)
+	checker.Set(0, 1, testWhite)
+	checker.Set(1, 1, testBlack)
+	for _, tc := range []struct {
+		periodic Periodicity
+		want     bool
+	}{
+		{Periodicity{true, false}, true},
+		{Periodicity{false, true}, false},
+	} {
+		om, err := NewOverlappingImages([]OverlappingSample{{Image: checker, Periodic: Periodicity{true, true}}}, OverlappingOptions{
+			N:        2,
+			Width:    4,
+			Height:   5,
+			Periodic: tc.periodic,
+			Symmetry: SymIdentity,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := om.Run(testSeed, 0); got != tc.want {
+			t.Errorf("output periodic %v: Run = %v, want %v", tc.periodic, got, tc.want)
+		}
+		if got, want := om.OnBoundary(3, 0), !tc.periodic.X; got != want {
+			t.Errorf("output periodic %v: OnBoundary(3, 0) = %v, want %v", tc.periodic, got, want)
+		}
+		if got, want := om.OnBoundary(0, 4), !tc.periodic.Y; got != want {
+			t.Errorf("output periodic %v: OnBoundary(0, 4) = %v, want %v", tc.periodic, got, want)
+		}
+	}
+}
+
+func TestOverlappingSamplesPath(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "stripes.png"), pngFile(t, stripes(6, 3, testRed)).Data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rel, err := filepath.Rel(wd, filepath.Join(dir, "stripes"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// an empty path is the working directory, as with filepath.Join.
+	opt := OverlappingOptions{N: 2, Width: 8, Height: 8, Symmetry: SymIdentity}
+	for _, name := range []string{rel, filepath.Join(dir, "stripes")} {
+		if _, err := NewOverlappingSamples("", []OverlappingSample{{Name: name}}, opt); err != nil {
+			t.Errorf("NewOverlappingSamples(\"\", %q): %v", name, err)
+		}
+	}
+}
+
+func TestOverlappingNoImages(t *testing.T) {
+	if _, err := NewOverlappingImages(nil, OverlappingOptions{N: 2}); err == nil {
+		t.Error("expected error for empty sample list")
+	}
+}
+
+func TestParseSymmetry(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Symmetry
+	}{
+		{"1", SymIdentity},
+		{"2", SymIdentity | SymMirrorX},
+		{"8", SymAll},
+		{"none", SymIdentity},
+		{"x-mirror", SymIdentity | SymMirrorX},
+		{"y-mirror|rot180", SymIdentity | SymMirrorY | SymRotate180},
+		{"0x11", SymIdentity | SymRotate180},
+		{"0b100001", SymIdentity | SymMirrorY},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseSymmetry(tt.in)
+		if err != nil {
+			t.Errorf("ParseSymmetry(%q): %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseSymmetry(%q) = %#b, want %#b", tt.in, got, tt.want)
+		}
+	}
+
+	for _, in := range []string{"0", "9", "sideways", "0x100"} {
+		if _, err := ParseSymmetry(in); err == nil {
+			t.Errorf("ParseSymmetry(%q): expected error", in)
+		}
+	}
+}
+
+func TestOverlappingIntrospection(t *testing.T) {
+	samples := []OverlappingSample{
+		{Image: stripes(6, 3, testBlack), Periodic: Periodicity{true, true}},
+	}
+
+	om, err := NewOverlappingImages(samples, OverlappingOptions{N: 2, Width: 8, Height: 8, Symmetry: SymIdentity})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	patterns := om.Patterns()
+	if len(patterns) != om.T {
+		t.Fatalf("%d patterns, want %d", len(patterns), om.T)
+	}
+
+	palette := om.Palette()
+	for _, p := range patterns {
+		img := om.PatternImage(p.Index)
+		for i, c := range p.Pixels {
+			if got := img.At(i%om.N, i/om.N); got != palette[c] {
+				t.Errorf("pattern %d pixel %d = %v, want %v", p.Index, i, got, palette[c])
+			}
+		}
+
+		// every pattern agrees with itself when fully overlapping.
+		var self bool
+		for _, t2 := range om.Compatible(p.Index, 0, 0) {
+			self = self || t2 == p.Index
+		}
+		if !self {
+			t.Errorf("pattern %d not compatible with itself", p.Index)
+		}
+	}
+
+	if n := len(om.Compatible(0, om.N, 0)); n != om.T {
+		t.Errorf("non-overlapping offset allows %d patterns, want %d", n, om.T)
+	}
+
+	stats := om.Stats()
+	if stats.T != om.T || stats.Colors != 2 {
+		t.Errorf("stats = %+v", stats)
+	}
+	if stats.PropagatorDensity <= 0 || stats.PropagatorDensity > 1 {
+		t.Errorf("propagator density %v out of range", stats.PropagatorDensity)
+	}
+}
+
+func TestOverlappingPaletted(t *testing.T) {
+	// translucent, unsaturated colours, which only survive the blender and
+	// the palette exactly if neither rounds them.
+	line := color.NRGBA{0x12, 0x9a, 0x5d, 0x81}
+	back := color.NRGBA{0xd3, 0x47, 0x86, 0xc3}
+	sample := image.NewNRGBA(image.Rect(0, 0, 6, 6))
+	for y := 0; y < 6; y++ {
+		for x := 0; x < 6; x++ {
+			if x%3 == 0 {
+				sample.SetNRGBA(x, y, line)
+			} else {
+				sample.SetNRGBA(x, y, back)
+			}
+		}
+	}
+	samples := []OverlappingSample{
+		{Image: sample, Periodic: Periodicity{true, true}},
+	}
+
+	om, err := NewOverlappingImages(samples, OverlappingOptions{
+		N:        2,
+		Width:    12,
+		Height:   12,
+		Periodic: Periodicity{true, true},
+		Symmetry: SymIdentity,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !om.Run(testSeed, 0) {
+		t.Fatal("CONTRADICTION")
+	}
+
+	pal, err := om.Paletted()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img, err := om.Graphics()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a collapsed output only uses sample colours: the paletted rendering
+	// holds them exactly, and Graphics their premultiplied form.
+	for y := 0; y < 12; y++ {
+		for x := 0; x < 12; x++ {
+			c := pal.At(x, y)
+			if c != line && c != back {
+				t.Fatalf("pixel (%d, %d): paletted %v, want a sample colour", x, y, c)
+			}
+			if got, want := img.At(x, y), color.RGBAModel.Convert(c); got != want {
+				t.Fatalf("pixel (%d, %d): graphics %v, want %v", x, y, got, want)
+			}
+		}
+	}
+}
+
+func TestPropagatorAgrees(t *testing.T) {
+	const N = 3
+	patterns := [][]byte{
+		{0, 0, 0, 0, 1, 0, 0, 0, 0},
+		{0, 1, 0, 0, 1, 0, 0, 1, 0},
+		{0, 0, 0, 1, 1, 1, 0, 0, 0},
+		{1, 1, 1, 1, 1, 1, 1, 1, 1},
+	}
+
+	agrees := func(p1, p2 []byte, dx, dy int) bool {
+		for y := 0; y < N; y++ {
+			for x := 0; x < N; x++ {
+				x2, y2 := x-dx, y-dy
+				if x2 < 0 || x2 >= N || y2 < 0 || y2 >= N {
+					continue
+				}
+				if p1[x+N*y] != p2[x2+N*y2] {
+					return false
+				}
+			}
+		}
+		return true
+	}
+
+	prop := newPropagator(patterns, N, agrees)
+	for t1 := range patterns {
+		for t2 := range patterns {
+			for dy := -N + 1; dy < N; dy++ {
+				for dx := -N + 1; dx < N; dx++ {
+					want := agrees(patterns[t1], patterns[t2], dx, dy)
+					if got := prop.agrees(t1, t2, dx, dy); got != want {
+						t.Errorf("agrees(%d, %d, %d, %d) = %v, want %v", t1, t2, dx, dy, got, want)
+					}
+				}
+			}
+		}
+	}
+
+	if max := len(patterns) * prop.half(); len(prop.sets) >= max {
+		t.Errorf("%d distinct sets, want fewer than %d", len(prop.sets), max)
+	}
+}
+
+func TestOverlappingIgnore(t *testing.T) {
+	// the right half of the sample is unfinished and transparent.
+	img := stripes(8, 4, testBlack)
+	for y := 0; y < 8; y++ {
+		for x := 4; x < 8; x++ {
+			img.Set(x, y, color.Transparent)
+		}
+	}
+
+	samples := []OverlappingSample{{Image: img, Periodic: Periodicity{true, true}}}
+	opt := OverlappingOptions{
+		N:        2,
+		Width:    8,
+		Height:   8,
+		Periodic: Periodicity{true, true},
+		Symmetry: SymIdentity,
+		Ignore:   color.NRGBA{0xff, 0xff, 0xff, 0x00},
+	}
+
+	skip, err := NewOverlappingImages(samples, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range skip.Patterns() {
+		if bytes.IndexByte(p.Pixels, byte(skip.ignore)) >= 0 {
+			t.Errorf("pattern %d contains an ignored pixel", p.Index)
+		}
+	}
+
+	opt.IgnoreMode = IgnoreWildcard
+	wild, err := NewOverlappingImages(samples, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wild.T <= skip.T {
+		t.Errorf("wildcard kept %d patterns, skip kept %d", wild.T, skip.T)
+	}
+
+	// a fully ignored pattern agrees with every other pattern.
+	var ignored int
+	for _, p := range wild.Patterns() {
+		if bytes.Count(p.Pixels, []byte{byte(wild.ignore)}) != len(p.Pixels) {
+			continue
+		}
+		ignored++
+		if n := len(wild.Compatible(p.Index, 1, 0)); n != wild.T {
+			t.Errorf("wildcard pattern compatible with %d of %d patterns", n, wild.T)
+		}
+	}
+	if ignored == 0 {
+		t.Error("no fully ignored pattern among the wildcard patterns")
+	}
+}
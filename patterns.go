@@ -0,0 +1,117 @@
+package bohm
+
+import (
+	"image"
+	"image/color"
+	"strconv"
+)
+
+// Pattern is one of the N by N patterns learned by an overlapping model.
+type Pattern struct {
+	Index  int
+	Weight float64
+
+	// Pixels holds palette indices in row-major order.
+	Pixels []byte
+}
+
+// Patterns lists the learned patterns in extraction order.
+func (om *Overlapping) Patterns() []Pattern {
+	list := make([]Pattern, om.T)
+	for t := range list {
+		list[t] = Pattern{
+			Index:  t,
+			Weight: om.stationary[t],
+			Pixels: append([]byte(nil), om.patterns[t]...),
+		}
+	}
+	return list
+}
+
+// Palette returns the colours shared by every sample, indexed by the
+// pixels of each Pattern.
+func (om *Overlapping) Palette() color.Palette {
+	return append(color.Palette(nil), om.colors...)
+}
+
+// PatternImage renders pattern t as an N by N image.
+func (om *Overlapping) PatternImage(t int) *image.Paletted {
+	img := image.NewPaletted(image.Rect(0, 0, om.N, om.N), om.colors)
+	copy(img.Pix, om.patterns[t])
+	return img
+}
+
+// Compatible lists the patterns that may be placed at offset (dx, dy)
+// from pattern t. Patterns further than N-1 apart do not overlap, so every
+// pattern is compatible.
+func (om *Overlapping) Compatible(t, dx, dy int) []int {
+	if dx <= -om.N || dx >= om.N || dy <= -om.N || dy >= om.N {
+		list := make([]int, om.T)
+		for t2 := range list {
+			list[t2] = t2
+		}
+		return list
+	}
+
+	var list []int
+	for t2 := 0; t2 < om.T; t2++ {
+		if om.propagator.agrees(t, t2, dx, dy) {
+			list = append(list, t2)
+		}
+	}
+	return list
+}
+
+type OverlappingStats struct {
+	T      int
+	Colors int
+
+	// PropagatorDensity is the fraction of (pattern, offset, pattern)
+	// triples that agree.
+	PropagatorDensity float64
+
+	PropagatorBytes int
+	WaveBytes       int
+}
+
+// Stats summarises the learned model and its approximate memory use. It
+// is available as soon as the model is built, before running it.
+func (om *Overlapping) Stats() OverlappingStats {
+	stats := OverlappingStats{
+		T:               om.T,
+		Colors:          len(om.colors),
+		PropagatorBytes: om.propagator.bytes(),
+		WaveBytes:       om.FM.X * (2*sliceSize + om.FM.Y*(sliceSize+om.T+1)),
+	}
+
+	prop := om.propagator
+	half := prop.half()
+
+	// every stored offset except (0, 0) stands for itself and its mirror.
+	var entries int
+	for t := 0; t < om.T; t++ {
+		for k := 0; k < half; k++ {
+			n := 0
+			forBits(prop.set(t, k), func(int) { n++ })
+			if dx, dy := prop.offset(k); dx == 0 && dy == 0 {
+				entries += n
+			} else {
+				entries += 2 * n
+			}
+		}
+	}
+
+	D := 2*om.N - 1
+	if total := om.T * om.T * D * D; total > 0 {
+		stats.PropagatorDensity = float64(entries) / float64(total)
+	}
+
+	return stats
+}
+
+// Bytes is the approximate memory footprint of the model.
+func (s OverlappingStats) Bytes() int {
+	return s.PropagatorBytes + s.WaveBytes
+}
+
+const sliceSize = 3 * strconv.IntSize / 8
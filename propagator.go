@@ -0,0 +1,133 @@
+package bohm
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// propagator records which patterns may overlap each other. For pattern t
+// and offset (dx, dy), |dx|, |dy| < N, it holds the bitset of patterns
+// that agree with t when placed at that offset.
+//
+// Identical bitsets are stored once, and only half of the offsets are
+// kept: t2 agrees with t at (dx, dy) exactly when t agrees with t2 at
+// (-dx, -dy).
+type propagator struct {
+	N, T  int
+	words int
+
+	sets  [][]uint64
+	index []int32
+}
+
+func newPropagator(patterns [][]byte, N int, agrees func(p1, p2 []byte, dx, dy int) bool) *propagator {
+	T := len(patterns)
+	p := &propagator{
+		N:     N,
+		T:     T,
+		words: (T + 63) / 64,
+	}
+
+	half := p.half()
+	p.index = make([]int32, T*half)
+
+	seen := make(map[string]int32)
+	key := make([]byte, p.words*8)
+	for t := range patterns {
+		for k := 0; k < half; k++ {
+			dx, dy := p.offset(k)
+
+			set := make([]uint64, p.words)
+			for t2 := range patterns {
+				if agrees(patterns[t], patterns[t2], dx, dy) {
+					set[t2/64] |= 1 << uint(t2%64)
+				}
+			}
+
+			for i, w := range set {
+				binary.LittleEndian.PutUint64(key[i*8:], w)
+			}
+
+			id, ok := seen[string(key)]
+			if !ok {
+				id = int32(len(p.sets))
+				seen[string(key)] = id
+				p.sets = append(p.sets, set)
+			}
+			p.index[t*half+k] = id
+		}
+	}
+
+	return p
+}
+
+// half is the number of stored offsets: those with dy > 0, or dy == 0 and
+// dx >= 0.
+func (p *propagator) half() int {
+	D := 2*p.N - 1
+	return (D*D + 1) / 2
+}
+
+// center is the row-major index of offset (0, 0) among all offsets.
+func (p *propagator) center() int {
+	return (p.N - 1) * (2 * p.N)
+}
+
+// offset returns the k-th stored offset.
+func (p *propagator) offset(k int) (dx, dy int) {
+	D := 2*p.N - 1
+	k += p.center()
+	return k%D - p.N + 1, k/D - p.N + 1
+}
+
+// stored reports whether (dx, dy) is one of the stored offsets and, if so,
+// its index.
+func (p *propagator) stored(dx, dy int) (int, bool) {
+	if dy < 0 || (dy == 0 && dx < 0) {
+		return 0, false
+	}
+	D := 2*p.N - 1
+	return (dy+p.N-1)*D + dx + p.N - 1 - p.center(), true
+}
+
+// set returns the patterns agreeing with t at the k-th stored offset.
+func (p *propagator) set(t, k int) []uint64 {
+	return p.sets[p.index[t*p.half()+k]]
+}
+
+// agrees reports whether t2 may be placed at offset (dx, dy) from t.
+func (p *propagator) agrees(t, t2, dx, dy int) bool {
+	if k, ok := p.stored(dx, dy); ok {
+		return hasBit(p.set(t, k), t2)
+	}
+	k, _ := p.stored(-dx, -dy)
+	return hasBit(p.set(t2, k), t)
+}
+
+// bytes approximates the memory held by the propagator.
+func (p *propagator) bytes() int {
+	return len(p.sets)*(p.words*8+sliceSize) + len(p.index)*4
+}
+
+func hasBit(set []uint64, i int) bool {
+	return set[i/64]&(1<<uint(i%64)) != 0
+}
+
+func intersects(a, b []uint64) bool {
+	for i := range a {
+		if a[i]&b[i] != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// forBits calls fn for the index of every set bit.
+func forBits(set []uint64, fn func(int)) {
+	for i, w := range set {
+		for w != 0 {
+			fn(i*64 + bits.TrailingZeros64(w))
+			w &= w - 1
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package bohm
+
+import (
+	"image"
+
+	"vallon.me/bohm/config"
+)
+
+// Region restricts the cells of the output within Rect to the tiles of a
+// subset expression.
+type Region struct {
+	Rect   image.Rectangle
+	Subset string
+}
+
+type region struct {
+	rect    image.Rectangle
+	allowed []bool
+}
+
+func (tm *Tiled) resolveRegions(ts *config.TileSet, regions []Region) error {
+	bounds := image.Rect(0, 0, tm.FM.X, tm.FM.Y)
+	for _, r := range regions {
+		set, err := ts.SubsetList(r.Subset)
+		if err != nil {
+			return err
+		}
+
+		allowed := make([]bool, len(tm.action))
+		for t, name := range tm.names {
+			allowed[t] = set == nil || set.Contains(name)
+		}
+		tm.regions = append(tm.regions, region{r.Rect.Intersect(bounds), allowed})
+	}
+	return nil
+}
+
+// pinRegions bans the tiles outside the subset of each region from its
+// cells. It reports whether any state was banned.
+func (tm *Tiled) pinRegions() bool {
+	var change bool
+	for _, r := range tm.regions {
+		for x := r.rect.Min.X; x < r.rect.Max.X; x++ {
+			for y := r.rect.Min.Y; y < r.rect.Max.Y; y++ {
+				for t, on := range tm.wave[x][y] {
+					if on && !r.allowed[t] {
+						tm.wave[x][y][t] = false
+						tm.changes[x][y] = true
+						change = true
+					}
+				}
+			}
+		}
+	}
+	return change
+}
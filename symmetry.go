@@ -0,0 +1,78 @@
+package bohm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Symmetry is a set of the eight dihedral transforms applied to every
+// pattern taken from an overlapping sample.
+type Symmetry uint8
+
+const (
+	SymIdentity     Symmetry = 1 << iota
+	SymMirrorX               // mirror left to right
+	SymRotate90              // rotate a quarter turn
+	SymAntiDiagonal          // reflect across the anti-diagonal
+	SymRotate180             // rotate a half turn
+	SymMirrorY               // mirror top to bottom
+	SymRotate270             // rotate three quarter turns
+	SymDiagonal              // reflect across the main diagonal
+
+	SymAll Symmetry = 0xff
+)
+
+var symmetryNames = map[string]Symmetry{
+	"none":          SymIdentity,
+	"identity":      SymIdentity,
+	"x-mirror":      SymIdentity | SymMirrorX,
+	"y-mirror":      SymIdentity | SymMirrorY,
+	"xy-mirror":     SymIdentity | SymMirrorX | SymMirrorY | SymRotate180,
+	"diagonal":      SymIdentity | SymDiagonal,
+	"anti-diagonal": SymIdentity | SymAntiDiagonal,
+	"rot180":        SymIdentity | SymRotate180,
+	"rot90":         SymIdentity | SymRotate90 | SymRotate180 | SymRotate270,
+	"all":           SymAll,
+}
+
+// SymmetryFirst returns the first k transforms in the order used by the
+// original integer symmetry parameter.
+func SymmetryFirst(k int) Symmetry {
+	if k >= 8 {
+		return SymAll
+	}
+	return Symmetry(1<<uint(k) - 1)
+}
+
+// ParseSymmetry parses a symmetry attribute. It accepts a legacy transform
+// count ("1" to "8"), a hexadecimal or binary bitmask ("0x05", "0b11") or
+// named sets joined with '|' ("x-mirror|rot180").
+func ParseSymmetry(s string) (Symmetry, error) {
+	s = strings.TrimSpace(s)
+
+	if strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0b") {
+		mask, err := strconv.ParseUint(s, 0, 8)
+		if err != nil {
+			return 0, fmt.Errorf("bohm: bad symmetry mask %q", s)
+		}
+		return Symmetry(mask), nil
+	}
+
+	if k, err := strconv.Atoi(s); err == nil {
+		if k < 1 || k > 8 {
+			return 0, fmt.Errorf("bohm: symmetry %d out of range [1, 8]", k)
+		}
+		return SymmetryFirst(k), nil
+	}
+
+	var sym Symmetry
+	for _, name := range strings.Split(s, "|") {
+		set, ok := symmetryNames[strings.ToLower(strings.TrimSpace(name))]
+		if !ok {
+			return 0, fmt.Errorf("bohm: unknown symmetry %q", name)
+		}
+		sym |= set
+	}
+	return sym, nil
+}
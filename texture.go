@@ -1,21 +1,130 @@
 package bohm
 
 import (
+	"fmt"
 	"image"
+	"image/draw"
+	"io/fs"
 	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+
+	"vallon.me/bohm/config"
 )
 
+// osDir is a directory of the operating system's filesystem for the path
+// based constructors. Unlike os.DirFS it opens any name filepath.Join
+// accepts, so "" is the working directory and names may be absolute or
+// contain "..".
+type osDir string
+
+func (dir osDir) Open(name string) (fs.File, error) {
+	return os.Open(filepath.Join(string(dir), filepath.FromSlash(name)))
+}
+
 type textureDef struct {
+	fsys              fs.FS
 	name              string
 	size, cardinality int
+
+	// atlas is set when name is an atlas and the tile is one of its cells.
+	atlas *config.Atlas
+	cell  int
+
+	// img is the image of a tile built in code, named by name.
+	img image.Image
+}
+
+// tileDefs returns the textures of the orientations of a tile, which are
+// its own images, cells of the atlas or image files within root.
+func tileDefs(fsys fs.FS, root string, ts *config.TileSet, tile config.Tile, cardinality int) ([]textureDef, error) {
+	var defs []textureDef
+	switch {
+	case len(tile.Images) != 0:
+		if ts.Unique && len(tile.Images) != cardinality {
+			return nil, fmt.Errorf("bohm: tile %q has %d images, want %d", tile.Name, len(tile.Images), cardinality)
+		}
+
+		for t := 0; t < cardinality; t++ {
+			def := textureDef{name: tile.Name, cardinality: t, img: tile.Images[0]}
+			if ts.Unique {
+				def.name, def.cardinality, def.img = fmt.Sprintf("%s %d", tile.Name, t), 0, tile.Images[t]
+			}
+			defs = append(defs, def)
+		}
+
+	case fsys == nil:
+		return nil, fmt.Errorf("bohm: tile %q has no image and there is no filesystem to read one from", tile.Name)
+
+	case ts.Atlas != nil:
+		cells, err := tile.Cells()
+		if err != nil {
+			return nil, err
+		}
+
+		if ts.Unique && len(cells) != cardinality {
+			return nil, fmt.Errorf("bohm: tile %q has %d atlas cells, want %d", tile.Name, len(cells), cardinality)
+		} else if !ts.Unique && len(cells) != 1 {
+			return nil, fmt.Errorf("bohm: tile %q has %d atlas cells, want 1", tile.Name, len(cells))
+		}
+
+		file := path.Join(root, ts.Atlas.Image)
+		for t := 0; t < cardinality; t++ {
+			def := textureDef{
+				fsys:        fsys,
+				name:        file,
+				cardinality: t,
+				atlas:       ts.Atlas,
+				cell:        cells[0],
+			}
+			if ts.Unique {
+				def.cardinality, def.cell = 0, cells[t]
+			}
+			defs = append(defs, def)
+		}
+
+	case ts.Unique:
+		for t := 0; t < cardinality; t++ {
+			file := path.Join(root, fmt.Sprintf("%s %d.png", tile.Name, t))
+			defs = append(defs, textureDef{fsys: fsys, name: file})
+		}
+
+	default:
+		file := path.Join(root, tile.Name+".png")
+		if tile.Image != "" {
+			file = path.Join(root, tile.Image)
+		}
+		for t := 0; t < cardinality; t++ {
+			defs = append(defs, textureDef{fsys: fsys, name: file, cardinality: t})
+		}
+	}
+	return defs, nil
+}
+
+// key identifies the tile image, which may be shared by several
+// orientations.
+func (def textureDef) key() string {
+	if def.atlas == nil {
+		return def.name
+	}
+	return def.name + "#" + strconv.Itoa(def.cell)
 }
 
 func (def textureDef) Open() (*texture, error) {
-	if t, ok := textureCache[def.name]; ok {
-		return t, nil
+	img, err := def.decode()
+	if err != nil {
+		return nil, err
+	}
+	return def.load(img)
+}
+
+func (def textureDef) decode() (image.Image, error) {
+	if def.img != nil {
+		return def.img, nil
 	}
 
-	f, err := os.Open(def.name)
+	f, err := def.fsys.Open(def.name)
 	if err != nil {
 		return nil, err
 	}
@@ -23,20 +132,76 @@ func (def textureDef) Open() (*texture, error) {
 
 	img, _, err := image.Decode(f)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("bohm: tile %s: %v", def.name, err)
 	}
+	return img, nil
+}
 
-	t := &texture{cardCache: [4][]uint8{img.(*image.RGBA).Pix}}
-	t.cardCache[0] = img.(*image.RGBA).Pix
+// load builds the texture from the decoded image, slicing out the tile's
+// cell if the image is an atlas.
+func (def textureDef) load(img image.Image) (*texture, error) {
+	if def.atlas != nil {
+		r, err := atlasCell(img.Bounds(), def.atlas, def.size, def.cell)
+		if err != nil {
+			return nil, fmt.Errorf("bohm: tile %s: %v", def.key(), err)
+		}
+
+		sub, ok := img.(interface {
+			SubImage(image.Rectangle) image.Image
+		})
+		if !ok {
+			return nil, fmt.Errorf("bohm: atlas %s cannot be sliced", def.name)
+		}
+		img = sub.SubImage(r)
+	}
+
+	pix, err := tilePix(img, def.size)
+	if err != nil {
+		return nil, fmt.Errorf("bohm: tile %s: %v", def.key(), err)
+	}
+
+	t := &texture{cardCache: [8][]uint8{pix}}
 	t.Size = def.size
 
-	textureCache[def.name] = t
 	return t, nil
 }
 
+// atlasCell returns the bounds of a cell within an atlas image.
+func atlasCell(bounds image.Rectangle, atlas *config.Atlas, size, cell int) (image.Rectangle, error) {
+	step := size + atlas.Spacing
+	columns := (bounds.Dx() - 2*atlas.Margin + atlas.Spacing) / step
+	rows := (bounds.Dy() - 2*atlas.Margin + atlas.Spacing) / step
+
+	if cell < 0 || cell >= columns*rows {
+		return image.Rectangle{}, fmt.Errorf("cell %d outside %dx%d atlas", cell, columns, rows)
+	}
+
+	min := bounds.Min.Add(image.Pt(atlas.Margin+cell%columns*step, atlas.Margin+cell/columns*step))
+	return image.Rectangle{min, min.Add(image.Pt(size, size))}, nil
+}
+
+// tilePix returns the pixels of a size by size tile in the premultiplied
+// RGBA layout used by the texture cache, converting from any colour model.
+func tilePix(img image.Image, size int) ([]uint8, error) {
+	b := img.Bounds()
+	if b.Dx() != size || b.Dy() != size {
+		return nil, fmt.Errorf("image is %dx%d, tileset size is %d", b.Dx(), b.Dy(), size)
+	}
+
+	if rgba, ok := img.(*image.RGBA); ok && rgba.Stride == 4*size && len(rgba.Pix) == 4*size*size {
+		return rgba.Pix, nil
+	}
+
+	rgba := image.NewRGBA(image.Rect(0, 0, size, size))
+	draw.Draw(rgba, rgba.Rect, img, b.Min, draw.Src)
+	return rgba.Pix, nil
+}
+
+// texture caches a tile in each of its eight orientations: 0 to 3 are
+// successive quarter turns, 4 to 7 mirror those left to right.
 type texture struct {
 	Size      int
-	cardCache [4][]uint8
+	cardCache [8][]uint8
 }
 
 func (t *texture) CarTile(cardinality int) []byte {
@@ -80,6 +245,18 @@ func (t *texture) buildCache(cardinality int) {
 				to[0], to[1], to[2], to[3] = from[0], from[1], from[2], from[3]
 			}
 		}
+
+	case 4, 5, 6, 7:
+		rotated := t.CarTile(cardinality - 4)
+		t.cardCache[cardinality] = make([]uint8, len(t.cardCache[0]))
+		for y := 0; y < t.Size; y++ {
+			row := y * t.Size
+			for x := 0; x < t.Size; x++ {
+				to := t.cardCache[cardinality][(row+x)*4:]
+				from := rotated[(row+t.Size-1-x)*4:]
+				to[0], to[1], to[2], to[3] = from[0], from[1], from[2], from[3]
+			}
+		}
 	}
 }
 
@@ -1,11 +1,17 @@
 package bohm
 
 import (
+	"errors"
 	"fmt"
 	"image"
 	"image/color"
-	"path/filepath"
+	"image/draw"
+	"io/fs"
+	"path"
+	"runtime"
 	"strconv"
+	"strings"
+	"sync"
 
 	"vallon.me/bohm/config"
 )
@@ -15,85 +21,180 @@ type textureFn func(x, y int) color.RGBA
 type Tiled struct {
 	propagator [4][][]bool
 
+	// weights scales the likelihood of allowed pairs in the same layout as
+	// propagator. It is nil unless a neighbor rule has a weight.
+	weights [4][][]float64
+
 	tiles    []textureDef
 	tileSize int
+	textures map[string]*texture
+	atlases  map[string]image.Image
+
+	action          [][8]int
+	names           []string
+	firstOccurrence map[string]int
+	regions         []region
 
 	black bool
 
+	// scratch space of GraphicsInto.
+	oriented [][]byte
+	opaque   []bool
+	buffers  []cellBuffer
+
 	//
 	FM       Point
-	periodic bool
+	periodic Periodicity
 
 	Model
 }
 
+type TiledOptions struct {
+	// Subset is a subset expression as described by
+	// config.TileSet.SubsetList. Weights overrides the weight of tiles by
+	// name, and Regions restricts parts of the output to other subsets.
+	Subset  string
+	Weights map[string]float64
+	Regions []Region
+
+	Width, Height int
+	Periodic      Periodicity
+	Black         bool
+
+	// AutoNeighbors derives neighbor rules by comparing tile edges, as if
+	// data.xml had adjacency="auto". Tolerance overrides its tolerance.
+	AutoNeighbors bool
+	Tolerance     int
+
+	// DetectSymmetry fills in the symmetry class of tiles that declare
+	// none from their images, and reports declared classes that disagree
+	// with the image to Warnf.
+	DetectSymmetry bool
+
+	// Warnf, if set, is told about mistakes in the tileset that do not
+	// stop the model from being built, such as a neighbor rule naming an
+	// orientation beyond those of its tile.
+	Warnf func(format string, v ...interface{})
+
+	// WangSet names the Wang set of a .tsx tileset, rather than its first.
+	WangSet string
+}
+
 func NewTiled(path, name, subsetName string, width, height int, periodic, black bool) *Tiled {
+	tm, err := NewTiledDir(path, name, TiledOptions{
+		Subset:   subsetName,
+		Width:    width,
+		Height:   height,
+		Periodic: Periodicity{periodic, periodic},
+		Black:    black,
+	})
+	if err != nil {
+		panic(err)
+	}
+	return tm
+}
+
+func NewTiledDir(path, name string, opt TiledOptions) (*Tiled, error) {
+	return NewTiledFS(osDir(path), name, opt)
+}
+
+// NewTiledFS builds a tiled model from the tileset rooted at root within
+// fsys. root holds data.xml and the tile images, or is a .tsx tileset of
+// the Tiled map editor whose Wang set gives the adjacency.
+func NewTiledFS(fsys fs.FS, root string, opt TiledOptions) (*Tiled, error) {
+	if path.Ext(root) == ".tsx" {
+		tileCfg, err := config.ReadTSX(fsys, root, opt.WangSet)
+		if err != nil {
+			return nil, err
+		}
+		return newTiled(fsys, path.Dir(root), tileCfg, opt)
+	}
+
+	tileCfg, err := config.ReadTileDataFS(fsys, path.Join(root, "data.xml"))
+	if err != nil {
+		return nil, err
+	}
+	return newTiled(fsys, root, tileCfg, opt)
+}
+
+// NewTiledFromSet builds a tiled model from a tileset built in code. Every
+// tile must carry its Images; those loaded from files, through Image, an
+// atlas or the tile name, need NewTiledFromSetFS.
+func NewTiledFromSet(ts *config.TileSet, opt TiledOptions) (*Tiled, error) {
+	return newTiled(nil, "", ts, opt)
+}
+
+// NewTiledFromSetFS is NewTiledFromSet for tilesets whose image files are
+// read from root within fsys.
+func NewTiledFromSetFS(fsys fs.FS, root string, ts *config.TileSet, opt TiledOptions) (*Tiled, error) {
+	return newTiled(fsys, root, ts, opt)
+}
+
+func newTiled(fsys fs.FS, root string, tileCfg *config.TileSet, opt TiledOptions) (*Tiled, error) {
 	tm := &Tiled{
-		FM:       Point{width, height},
-		periodic: periodic,
-		black:    black,
+		FM:       Point{opt.Width, opt.Height},
+		periodic: opt.Periodic,
+		black:    opt.Black,
 	}
 
 	tm.Model = NewModel(tm)
 
-	tileCfg := config.ReadTileData(filepath.Join(path, name, "data.xml"))
+	if atlas := tileCfg.Atlas; atlas != nil && atlas.TileSize != 0 {
+		if tileCfg.Size == 0 {
+			tileCfg.Size = atlas.TileSize
+		} else if tileCfg.Size != atlas.TileSize {
+			return nil, fmt.Errorf("bohm: atlas tile size %d, tileset size is %d", atlas.TileSize, tileCfg.Size)
+		}
+	}
 	if tileCfg.Size == 0 {
 		tileCfg.Size = 16
 	}
 
 	tm.tileSize = tileCfg.Size
 
-	subset := tileCfg.SubsetList(subsetName)
+	if opt.DetectSymmetry && !tileCfg.Unique {
+		detected, err := tm.detectTileSymmetry(fsys, root, tileCfg)
+		if err != nil {
+			return nil, err
+		}
+
+		// the detected classes must not leak into the caller's tileset.
+		cfg := *tileCfg
+		cfg.Tiles = append([]config.Tile(nil), tileCfg.Tiles...)
+		tileCfg = &cfg
+
+		for i, ts := range detected {
+			switch {
+			case ts.Declared == "":
+				tileCfg.Tiles[i].Symmetry = ts.Detected
+			case ts.Declared != ts.Detected && opt.Warnf != nil:
+				opt.Warnf("bohm: tile %q declares symmetry %s, image has %s", ts.Name, ts.Declared, ts.Detected)
+			}
+		}
+	}
+
+	subset, err := tileCfg.SubsetList(opt.Subset)
+	if err != nil {
+		return nil, err
+	}
 
 	tm.stationary = tm.stationary[0:0]
 
 	var action [][8]int
 	firstOccurrence := make(map[string]int)
+	tm.firstOccurrence = firstOccurrence
 	for _, tile := range tileCfg.Tiles {
 		tilename := tile.Name
 		if len(subset) != 0 && !subset.Contains(tilename) {
 			continue
 		}
 
-		var a, b func(int) int
-		var cardinality int
-		switch tile.Symmetry {
-		case "L":
-			cardinality = 4
-			a = func(i int) int { return (i + 1) % 4 }
-			b = func(i int) int {
-				if i%2 == 0 {
-					return i + 1
-				}
-				return i - 1
-			}
-		case "T":
-			cardinality = 4
-			a = func(i int) int { return (i + 1) % 4 }
-			b = func(i int) int {
-				if i%2 == 0 {
-					return i
-				}
-				return 4 - i
-			}
-		case "I":
-			cardinality = 2
-			a = func(i int) int { return 1 - i }
-			b = func(i int) int { return i }
-		case "\\":
-			cardinality = 2
-			a = func(i int) int { return 1 - i }
-			b = func(i int) int { return 1 - i }
-		default:
-			cardinality = 1
-			a = func(i int) int { return i }
-			b = func(i int) int { return i }
-		}
+		cardinality, a, b := symmetryClass(tile.Symmetry)
 
 		T := len(action)
 
 		firstOccurrence[tilename] = T
-		var cmap_ [4][8]int
+		var cmap_ [8][8]int
 		cmap := cmap_[:cardinality]
 		for t := range cmap {
 			cmap[t][0] = T + t
@@ -106,34 +207,44 @@ func NewTiled(path, name, subsetName string, width, height int, periodic, black
 			cmap[t][7] = T + b(a(a(a(t))))
 
 			action = append(action, cmap[t])
+			tm.names = append(tm.names, tilename)
 		}
 
-		if tileCfg.Unique {
-			for t := 0; t < cardinality; t++ {
-				file := filepath.Join(path, name, fmt.Sprintf("%s %d.png", tile.Name, t))
-				tm.tiles = append(tm.tiles, textureDef{name: file, size: tm.tileSize})
-			}
-		} else {
-			file := filepath.Join(path, name, tile.Name+".png")
-			for t := 0; t < cardinality; t++ {
-				tm.tiles = append(tm.tiles, textureDef{
-					name:        file,
-					size:        tm.tileSize,
-					cardinality: t,
-				})
-			}
+		defs, err := tileDefs(fsys, root, tileCfg, tile, cardinality)
+		if err != nil {
+			return nil, err
 		}
+		for i := range defs {
+			defs[i].size = tm.tileSize
+		}
+		tm.tiles = append(tm.tiles, defs...)
 
+		weight := tile.Weight
+		if w := subset[tilename]; w != 0 {
+			weight = w
+		}
+		if w := opt.Weights[tilename]; w != 0 {
+			weight = w
+		}
+		if weight == 0 {
+			weight = 1
+		}
 		for t := 0; t < cardinality; t++ {
-			weight := tile.Weight
-			if weight == 0 {
-				weight = 1
-			}
 			tm.stationary = append(tm.stationary, weight)
 		}
 	}
 
 	T := len(action)
+	if T == 0 && opt.Subset != "" {
+		return nil, fmt.Errorf("bohm: subset %q selects none of the tiles", opt.Subset)
+	} else if T == 0 {
+		return nil, errors.New("bohm: tileset has no tiles")
+	}
+	tm.action = action
+
+	if err := tm.resolveRegions(tileCfg, opt.Regions); err != nil {
+		return nil, err
+	}
 
 	for d := range tm.propagator {
 		tm.propagator[d] = make([][]bool, T)
@@ -153,62 +264,130 @@ func NewTiled(path, name, subsetName string, width, height int, periodic, black
 		}
 	}
 
-	for _, neighbor := range tileCfg.Neighbors {
-		split := func(s string) [2]string {
-			for i, c := range s {
-				if c == ' ' {
-					return [2]string{s[:i], s[i+1:]}
-				}
-			}
-			return [2]string{s, ""}
+	if opt.AutoNeighbors || tileCfg.Adjacency == "auto" {
+		tolerance := opt.Tolerance
+		if tolerance == 0 {
+			tolerance = tileCfg.Tolerance
 		}
 
-		left := split(neighbor.Left)
-		right := split(neighbor.Right)
-
-		if len(subset) != 0 && (!subset.Contains(left[0]) || !subset.Contains(right[0])) {
-			continue
+		if err := tm.deriveNeighbors(tolerance); err != nil {
+			return nil, err
 		}
+	}
+
+	if err := tm.socketNeighbors(tileCfg); err != nil {
+		return nil, err
+	}
 
-		var lInd, rInd int
-		if left[1] != "" {
-			var err error
-			if lInd, err = strconv.Atoi(left[1]); err != nil {
-				panic(err)
+	// cardinality holds the number of orientations of every tile, in the
+	// subset or not.
+	cardinality := make(map[string]int)
+	for _, tile := range tileCfg.Tiles {
+		cardinality[tile.Name], _, _ = symmetryClass(tile.Symmetry)
+	}
+
+	for _, neighbor := range tileCfg.Neighbors {
+		var refs [2]int
+		inSubset := true
+		for i, ref := range [2]string{neighbor.Left, neighbor.Right} {
+			name, k, err := parseTileRef(ref)
+			if err != nil {
+				return nil, fmt.Errorf("bohm: data.xml:%d: %v", neighbor.Line, err)
+			}
+			n, known := cardinality[name]
+			if !known {
+				return nil, fmt.Errorf("bohm: data.xml:%d: unknown tile %q", neighbor.Line, name)
+			}
+			if err := checkOrientation(name, k, n); err != nil && opt.Warnf != nil {
+				opt.Warnf("bohm: data.xml:%d: %v", neighbor.Line, err)
 			}
-		}
 
-		if right[1] != "" {
-			var err error
-			if rInd, err = strconv.Atoi(right[1]); err != nil {
-				panic(err)
+			first, ok := firstOccurrence[name]
+			if inSubset = inSubset && ok; inSubset {
+				refs[i] = action[first][k]
 			}
 		}
+		if !inSubset {
+			continue
+		}
 
-		L := action[firstOccurrence[left[0]]][lInd]
-		D := action[L][1]
-		R := action[firstOccurrence[right[0]]][rInd]
-		U := action[R][1]
-
-		tm.propagator[0][L][R] = true
-		tm.propagator[0][action[L][6]][action[R][6]] = true
-		tm.propagator[0][action[R][4]][action[L][4]] = true
-		tm.propagator[0][action[R][2]][action[L][2]] = true
+		if neighbor.Weight < 0 {
+			return nil, fmt.Errorf("bohm: data.xml:%d: negative weight %v", neighbor.Line, neighbor.Weight)
+		}
+		if neighbor.Weight != 0 && tm.weights[0] == nil {
+			for d := range tm.weights {
+				tm.weights[d] = make([][]float64, T)
+				for t := range tm.weights[d] {
+					tm.weights[d][t] = make([]float64, T)
+					for t2 := range tm.weights[d][t] {
+						tm.weights[d][t][t2] = 1
+					}
+				}
+			}
+		}
 
-		tm.propagator[1][D][U] = true
-		tm.propagator[1][action[U][6]][action[D][6]] = true
-		tm.propagator[1][action[D][4]][action[U][4]] = true
-		tm.propagator[1][action[U][2]][action[D][2]] = true
+		expandNeighbor(action, refs[0], refs[1], func(d, t1, t2 int) {
+			tm.propagator[d][t1][t2] = true
+			if neighbor.Weight != 0 {
+				tm.weights[d][t1][t2] = neighbor.Weight
+			}
+		})
 	}
 
 	for t1 := range tm.propagator[2] {
 		for t2 := range tm.propagator[2][t1] {
 			tm.propagator[2][t1][t2] = tm.propagator[0][t2][t1]
 			tm.propagator[3][t1][t2] = tm.propagator[1][t2][t1]
+			if tm.weights[0] != nil {
+				tm.weights[2][t1][t2] = tm.weights[0][t2][t1]
+				tm.weights[3][t1][t2] = tm.weights[1][t2][t1]
+			}
 		}
 	}
 
-	return tm
+	return tm, nil
+}
+
+// parseTileRef splits a tile reference, a tile name optionally followed by a
+// space and an orientation k, into its parts. Orientation k of a tile is
+// its first orientation under action k, which names a distinct orientation
+// only while k is below the tile's cardinality; see checkOrientation.
+func parseTileRef(ref string) (name string, k int, err error) {
+	name, index, ok := strings.Cut(ref, " ")
+	if !ok {
+		return name, 0, nil
+	}
+
+	if k, err = strconv.Atoi(index); err != nil || k < 0 || k >= 8 {
+		return "", 0, fmt.Errorf("bad orientation %q in %q", index, ref)
+	}
+	return name, k, nil
+}
+
+// checkOrientation reports whether a tile with n orientations has
+// orientation k.
+func checkOrientation(name string, k, n int) error {
+	if k >= n {
+		return fmt.Errorf("orientation %d of tile %q is out of range, it has %d", k, name, n)
+	}
+	return nil
+}
+
+// expandNeighbor calls fn for every propagator entry implied by L being
+// allowed directly left of R, under the symmetries of both tiles.
+func expandNeighbor(action [][8]int, L, R int, fn func(d, t1, t2 int)) {
+	D := action[L][1]
+	U := action[R][1]
+
+	fn(0, L, R)
+	fn(0, action[L][6], action[R][6])
+	fn(0, action[R][4], action[L][4])
+	fn(0, action[R][2], action[L][2])
+
+	fn(1, D, U)
+	fn(1, action[U][6], action[D][6])
+	fn(1, action[D][4], action[U][4])
+	fn(1, action[U][2], action[D][2])
 }
 
 func (tm *Tiled) Propagate() bool {
@@ -216,46 +395,9 @@ func (tm *Tiled) Propagate() bool {
 	for x2 := range tm.changes {
 		for y2 := range tm.changes[x2] {
 			for d := range tm.propagator {
-				x1 := x2
-				y1 := y2
-
-				switch d {
-				case 0:
-					if x2 == 0 {
-						if !tm.periodic {
-							continue
-						}
-						x1 = tm.FM.X - 1
-					} else {
-						x1 = x2 - 1
-					}
-				case 1:
-					if y2 == tm.FM.Y-1 {
-						if !tm.periodic {
-							continue
-						}
-						y1 = 0
-					} else {
-						y1 = y2 + 1
-					}
-				case 2:
-					if x2 == tm.FM.X-1 {
-						if !tm.periodic {
-							continue
-						}
-						x1 = 0
-					} else {
-						x1 = x2 + 1
-					}
-				default:
-					if y2 == 0 {
-						if !tm.periodic {
-							continue
-						}
-						y1 = tm.FM.Y - 1
-					} else {
-						y1 = y2 - 1
-					}
+				x1, y1, ok := tm.neighbor(x2, y2, d)
+				if !ok {
+					continue
 				}
 
 				if !tm.changes[x1][y1] {
@@ -284,65 +426,365 @@ func (tm *Tiled) Propagate() bool {
 	return change
 }
 
+// modulate scales the distribution of (x, y) by the weights of its pairs
+// with the neighbours that have collapsed to a single tile.
+func (tm *Tiled) modulate(x, y int, distribution []float64) {
+	if tm.weights[0] == nil {
+		return
+	}
+
+	for d := range tm.weights {
+		x1, y1, ok := tm.neighbor(x, y, d)
+		if !ok {
+			continue
+		}
+
+		t1 := -1
+		for t, on := range tm.wave[x1][y1] {
+			if on {
+				if t1 >= 0 {
+					t1 = -1
+					break
+				}
+				t1 = t
+			}
+		}
+		if t1 < 0 {
+			continue
+		}
+
+		for t2 := range distribution {
+			distribution[t2] *= tm.weights[d][t1][t2]
+		}
+	}
+}
+
+// neighbor returns the cell whose states constrain (x2, y2) through
+// propagator[d]: the cell to its left, below, right and above for d from
+// 0 to 3. It reports false past an edge of a non-periodic output.
+func (tm *Tiled) neighbor(x2, y2, d int) (x1, y1 int, ok bool) {
+	x1, y1 = x2, y2
+
+	switch d {
+	case 0:
+		if x2 == 0 {
+			if !tm.periodic.X {
+				return 0, 0, false
+			}
+			x1 = tm.FM.X - 1
+		} else {
+			x1 = x2 - 1
+		}
+	case 1:
+		if y2 == tm.FM.Y-1 {
+			if !tm.periodic.Y {
+				return 0, 0, false
+			}
+			y1 = 0
+		} else {
+			y1 = y2 + 1
+		}
+	case 2:
+		if x2 == tm.FM.X-1 {
+			if !tm.periodic.X {
+				return 0, 0, false
+			}
+			x1 = 0
+		} else {
+			x1 = x2 + 1
+		}
+	default:
+		if y2 == 0 {
+			if !tm.periodic.Y {
+				return 0, 0, false
+			}
+			y1 = tm.FM.Y - 1
+		} else {
+			y1 = y2 - 1
+		}
+	}
+	return x1, y1, true
+}
+
 func (Tiled) OnBoundary(_, _ int) bool { return false }
 
+func (tm *Tiled) Clear() {
+	tm.Model.Clear()
+
+	edges := tm.pinEdges(tm.FM.X, tm.FM.Y)
+	if tm.pinRegions() || edges {
+		for tm.Propagate() {
+		}
+	}
+}
+
+// Indices resolves a comma separated list of tiles for use in an EdgeRule.
+// A bare tile name selects all of its orientations, "name k" selects its
+// orientation k as in the neighbor rules of data.xml.
+func (tm *Tiled) Indices(spec string) ([]int, error) {
+	var list []int
+	for _, f := range strings.Split(spec, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+
+		name, k, err := parseTileRef(f)
+		if err != nil {
+			return nil, fmt.Errorf("bohm: %v", err)
+		}
+
+		first, ok := tm.firstOccurrence[name]
+		if !ok {
+			return nil, fmt.Errorf("bohm: unknown tile %q", name)
+		}
+
+		n := 0
+		for first+n < len(tm.names) && tm.names[first+n] == name {
+			n++
+		}
+
+		// a tile without an orientation stands for all of them.
+		if name != f {
+			if err := checkOrientation(name, k, n); err != nil {
+				return nil, fmt.Errorf("bohm: %v", err)
+			}
+			list = append(list, tm.action[first][k])
+			continue
+		}
+
+		for t := first; t < first+n; t++ {
+			list = append(list, t)
+		}
+	}
+	return list, nil
+}
+
+// texture opens the image behind def, caching it on the model while
+// TextureCache is set.
+func (tm *Tiled) texture(def textureDef) (*texture, error) {
+	key := def.key()
+	if t, ok := tm.textures[key]; ok && TextureCache {
+		return t, nil
+	}
+
+	if tm.textures == nil {
+		tm.textures = make(map[string]*texture)
+		tm.atlases = make(map[string]image.Image)
+	}
+
+	var t *texture
+	var err error
+	if def.atlas == nil {
+		t, err = def.Open()
+	} else {
+		// an atlas is decoded once and shared by all of its cells.
+		img, ok := tm.atlases[def.name]
+		if !ok || !TextureCache {
+			if img, err = def.decode(); err != nil {
+				return nil, err
+			}
+			tm.atlases[def.name] = img
+		}
+		t, err = def.load(img)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	tm.textures[key] = t
+	return t, nil
+}
+
 func (tm *Tiled) Graphics() (image.Image, error) {
 	result := image.NewRGBA(image.Rect(0, 0, tm.FM.X*tm.tileSize, tm.FM.Y*tm.tileSize))
+	if err := tm.GraphicsInto(result, result.Bounds(), 1); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
 
-	tileBuf := make([]float64, tm.tileSize*tm.tileSize*4)
-	for x, col := range tm.wave {
-		for y, row := range col {
-			var amount int
-			var lambda float64
-			for t, on := range row {
-				if on {
-					amount++
-					lambda += tm.stationary[t]
-				}
-			}
+// GraphicsInto draws a viewport of the output as ModelDep describes.
+func (tm *Tiled) GraphicsInto(dst draw.Image, r image.Rectangle, scale int) error {
+	S := tm.tileSize
+	v, err := newViewport(dst, r, image.Rect(0, 0, tm.FM.X*S, tm.FM.Y*S), scale)
+	if err != nil || v.r.Empty() {
+		return err
+	}
 
-			for i := 0; i < len(tileBuf); i += 4 {
-				tileBuf[i] = 0x00
-				tileBuf[i+1] = 0x00
-				tileBuf[i+2] = 0x00
-				tileBuf[i+3] = 0x00
+	cells := image.Rect(v.r.Min.X/S, v.r.Min.Y/S, (v.r.Max.X+S-1)/S, (v.r.Max.Y+S-1)/S)
+	if err := tm.orientedTiles(cells); err != nil {
+		return err
+	}
+
+	// rows of cells are drawn in parallel, each worker blending into its
+	// own buffer, unless dst may not be safe to draw into concurrently.
+	workers := runtime.GOMAXPROCS(0)
+	if v.rgba == nil {
+		workers = 1
+	}
+	if workers > cells.Dy() {
+		workers = cells.Dy()
+	}
+	for len(tm.buffers) < workers {
+		tm.buffers = append(tm.buffers, cellBuffer{
+			blend:   make([]blender, S*S),
+			weights: make([]uint64, len(tm.tiles)),
+			pix:     make([]byte, 4*S*S),
+		})
+	}
+
+	rows := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(buf *cellBuffer) {
+			defer wg.Done()
+
+			for i := range buf.blend {
+				buf.blend[i].linear = tm.Blend == BlendLinear
 			}
 
-			if !tm.black || amount != len(row) {
-				for t, on := range row {
-					if !on {
-						continue
-					}
-					weight := tm.stationary[t] / lambda
+			for y := range rows {
+				for x := cells.Min.X; x < cells.Max.X; x++ {
+					rect := image.Rect(x*S, y*S, (x+1)*S, (y+1)*S)
 
-					texture, err := tm.tiles[t].Open()
-					if err != nil {
-						return nil, err
+					// whole unmagnified cells are drawn straight into dst.
+					if v.rgba != nil && v.scale == 1 && rect.In(v.r) {
+						b := v.block(rect.Min.X, rect.Min.Y)
+						tm.drawCell(v.rgba.Pix[v.rgba.PixOffset(b.Min.X, b.Min.Y):], v.rgba.Stride, x, y, buf)
+						continue
 					}
 
-					tile := texture.CarTile(tm.tiles[t].cardinality)
-					for p := 0; p < len(tileBuf); p += 4 {
-						tileBuf[p] += float64(tile[p]) * weight
-						tileBuf[p+1] += float64(tile[p+1]) * weight
-						tileBuf[p+2] += float64(tile[p+2]) * weight
-						tileBuf[p+3] += float64(tile[p+3]) * weight
-					}
+					tm.drawCell(buf.pix, 4*S, x, y, buf)
+					v.setPix(rect, buf.pix, 4*S)
 				}
 			}
+		}(&tm.buffers[w])
+	}
+	for y := cells.Min.Y; y < cells.Max.Y; y++ {
+		rows <- y
+	}
+	close(rows)
+	wg.Wait()
 
-			for yt := 0; yt < tm.tileSize; yt++ {
-				row := tileBuf[(yt*tm.tileSize)*4:]
-				for xt := 0; xt < tm.tileSize; xt++ {
-					cell := row[xt*4:]
-					result.SetRGBA(x*tm.tileSize+xt, y*tm.tileSize+yt, color.RGBA{
-						R: uint8(cell[0]),
-						G: uint8(cell[1]),
-						B: uint8(cell[2]),
-						A: uint8(cell[3]),
-					})
+	return nil
+}
+
+// cellBuffer is the scratch space of a worker of GraphicsInto.
+type cellBuffer struct {
+	blend   []blender
+	weights []uint64 // of each tile in the cell, in 16.16 fixed point
+	pix     []byte
+}
+
+// orientedTiles resolves the pixels of every orientation still allowed in
+// some cell of cells, and whether they are fully opaque. Resolved tiles
+// are kept for later calls while TextureCache is set.
+func (tm *Tiled) orientedTiles(cells image.Rectangle) error {
+	if len(tm.oriented) != len(tm.tiles) {
+		tm.oriented = make([][]byte, len(tm.tiles))
+		tm.opaque = make([]bool, len(tm.tiles))
+	}
+	if !TextureCache {
+		for t := range tm.oriented {
+			tm.oriented[t] = nil
+		}
+	}
+
+	for x := cells.Min.X; x < cells.Max.X; x++ {
+		for y := cells.Min.Y; y < cells.Max.Y; y++ {
+			for t, on := range tm.wave[x][y] {
+				if !on || tm.oriented[t] != nil {
+					continue
+				}
+
+				texture, err := tm.texture(tm.tiles[t])
+				if err != nil {
+					return err
 				}
+				pix := texture.CarTile(tm.tiles[t].cardinality)
+
+				tm.opaque[t] = true
+				for i := 3; i < len(pix); i += 4 {
+					tm.opaque[t] = tm.opaque[t] && pix[i] == 0xff
+				}
+				tm.oriented[t] = pix
 			}
 		}
 	}
-	return result, nil
+	return nil
+}
+
+// drawCell draws cell (x, y) into pix, whose rows are stride bytes apart,
+// blending its allowed tiles in buf.
+func (tm *Tiled) drawCell(pix []byte, stride, x, y int, buf *cellBuffer) {
+	S := tm.tileSize
+	row := tm.wave[x][y]
+
+	var amount, best int
+	var lambda float64
+	for t, on := range row {
+		if on {
+			if amount == 0 || tm.stationary[t] > tm.stationary[best] {
+				best = t
+			}
+			amount++
+			lambda += tm.stationary[t]
+		}
+	}
+
+	// a black or contradicted cell is blank.
+	if amount == 0 || (tm.black && amount == len(row)) {
+		for yt := 0; yt < S; yt++ {
+			line := pix[yt*stride : yt*stride+4*S]
+			for i := range line {
+				line[i] = 0
+			}
+		}
+		return
+	}
+
+	// a collapsed cell is its tile, unless linear blending could round
+	// translucent pixels.
+	if amount == 1 && (tm.Blend != BlendLinear || tm.opaque[best]) {
+		for yt := 0; yt < S; yt++ {
+			copy(pix[yt*stride:], tm.oriented[best][yt*4*S:(yt+1)*4*S])
+		}
+		return
+	}
+
+	// the weights are fixed once per cell, so that the pixels are blended
+	// in integers only. A tile of weight 0 would add nothing.
+	for t, on := range row {
+		buf.weights[t] = 0
+		if on && (tm.Blend != BlendMostProbable || t == best) {
+			buf.weights[t] = uint64(tm.stationary[t]/lambda*0x10000 + 0.5)
+		}
+	}
+
+	tileBuf := buf.blend
+	for i := range tileBuf {
+		tileBuf[i].reset()
+	}
+
+	for t, weight := range buf.weights {
+		if weight == 0 {
+			continue
+		}
+
+		tile := tm.oriented[t]
+		for i := range tileBuf {
+			p := tile[i*4:]
+			tileBuf[i].add(uint32(p[0])*0x101, uint32(p[1])*0x101, uint32(p[2])*0x101, uint32(p[3])*0x101, weight)
+		}
+	}
+
+	for yt := 0; yt < S; yt++ {
+		line := pix[yt*stride:]
+		for xt := 0; xt < S; xt++ {
+			c := tileBuf[yt*S+xt].rgba()
+			line[4*xt], line[4*xt+1], line[4*xt+2], line[4*xt+3] = c.R, c.G, c.B, c.A
+		}
+	}
 }
@@ -1,19 +1,137 @@
 package bohm
 
-import "testing"
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/fstest"
 
-const testSeed = 0
+	"vallon.me/bohm/config"
+)
+
+const (
+	testSeed    = 0
+	testSamples = "WaveFunctionCollapse-master/samples"
+)
+
+func pngFile(t testing.TB, img image.Image) *fstest.MapFile {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return &fstest.MapFile{Data: buf.Bytes()}
+}
+
+func solidTile(size int, c color.Color) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+// testTileFS is a tileset of two solid tiles that may only neighbour
+// themselves.
+func testTileFS(t testing.TB) fstest.MapFS {
+	return fstest.MapFS{
+		"tiles/data.xml": &fstest.MapFile{Data: []byte(`<set size="2">
+	<tiles>
+		<tile name="red" symmetry="X"/>
+		<tile name="blue" symmetry="X"/>
+	</tiles>
+	<neighbors>
+		<neighbor left="red" right="red"/>
+		<neighbor left="blue" right="blue"/>
+	</neighbors>
+</set>`)},
+		"tiles/red.png":  pngFile(t, solidTile(2, color.RGBA{0xff, 0, 0, 0xff})),
+		"tiles/blue.png": pngFile(t, solidTile(2, color.RGBA{0, 0, 0xff, 0xff})),
+	}
+}
+
+func TestTiledFS(t *testing.T) {
+	tm, err := NewTiledFS(testTileFS(t), "tiles", TiledOptions{Width: 4, Height: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !tm.Run(testSeed, 0) {
+		t.Fatal("CONTRADICTION")
+	}
+
+	img, err := tm.Graphics()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if b := img.Bounds(); b.Dx() != 8 || b.Dy() != 6 {
+		t.Fatalf("bounds = %v, want 8x6", b)
+	}
+
+	// the rules only allow a single colour across the whole output.
+	first := img.At(0, 0)
+	for y := 0; y < 6; y++ {
+		for x := 0; x < 8; x++ {
+			if c := img.At(x, y); c != first {
+				t.Fatalf("pixel (%d, %d) = %v, want %v", x, y, c, first)
+			}
+		}
+	}
+}
+
+func TestTiledDir(t *testing.T) {
+	dir := t.TempDir()
+	for name, f := range testTileFS(t) {
+		if err := os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), f.Data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rel, err := filepath.Rel(wd, filepath.Join(dir, "tiles"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// like filepath.Join, an empty path is the working directory, and
+	// names may be absolute or climb out of the path.
+	for _, tc := range [][2]string{
+		{"", rel},
+		{"", filepath.Join(dir, "tiles")},
+		{filepath.Join(dir, "other"), "../tiles"},
+	} {
+		if _, err := NewTiledDir(tc[0], tc[1], TiledOptions{Width: 2, Height: 2}); err != nil {
+			t.Errorf("NewTiledDir(%q, %q): %v", tc[0], tc[1], err)
+		}
+	}
+}
 
 func BenchmarkTiledCreate(b *testing.B) {
 	summer := func(b *testing.B) {
 		for i := 0; i < b.N; i++ {
-			m := NewTiled("Summer", "", 15, 15, false, false)
+			m := NewTiled(testSamples, "Summer", "", 15, 15, false, false)
 			_ = m
 		}
 	}
 	circuit := func(b *testing.B) {
 		for i := 0; i < b.N; i++ {
-			m := NewTiled("Circuit", "Turnless", 34, 34, true, false)
+			m := NewTiled(testSamples, "Circuit", "Turnless", 34, 34, true, false)
 			_ = m
 		}
 	}
@@ -23,8 +141,8 @@ func BenchmarkTiledCreate(b *testing.B) {
 }
 
 func BenchmarkTiledRun(b *testing.B) {
-	s := NewTiled("Summer", "", 15, 15, false, false)
-	c := NewTiled("Circuit", "Turnless", 34, 34, true, false)
+	s := NewTiled(testSamples, "Summer", "", 15, 15, false, false)
+	c := NewTiled(testSamples, "Circuit", "Turnless", 34, 34, true, false)
 
 	summer := func(b *testing.B) {
 		for i := 0; i < b.N; i++ {
@@ -46,12 +164,12 @@ func BenchmarkTiledRun(b *testing.B) {
 }
 
 func BenchmarkTiledGraphics(b *testing.B) {
-	s := NewTiled("Summer", "", 15, 15, false, false)
+	s := NewTiled(testSamples, "Summer", "", 15, 15, false, false)
 	if !s.Run(testSeed, 0) {
 		b.Error("Summer: CONTRADICTION")
 	}
 
-	c := NewTiled("Circuit", "Turnless", 34, 34, true, false)
+	c := NewTiled(testSamples, "Circuit", "Turnless", 34, 34, true, false)
 	if !c.Run(testSeed, 0) {
 		b.Error("Circuit: CONTRADICTION")
 	}
@@ -88,7 +206,7 @@ func BenchmarkTiledGraphicsNoCache(b *testing.B) {
 func BenchmarkTiledFull(b *testing.B) {
 	summer := func(b *testing.B) {
 		for i := 0; i < b.N; i++ {
-			s := NewTiled("Summer", "", 15, 15, false, false)
+			s := NewTiled(testSamples, "Summer", "", 15, 15, false, false)
 
 			if !s.Run(testSeed, 0) {
 				b.Error("Summer: CONTRADICTION")
@@ -103,7 +221,7 @@ func BenchmarkTiledFull(b *testing.B) {
 	}
 	circuit := func(b *testing.B) {
 		for i := 0; i < b.N; i++ {
-			c := NewTiled("Circuit", "Turnless", 34, 34, true, false)
+			c := NewTiled(testSamples, "Circuit", "Turnless", 34, 34, true, false)
 
 			if !c.Run(testSeed, 0) {
 				b.Error("Circuit: CONTRADICTION")
@@ -120,3 +238,740 @@ func BenchmarkTiledFull(b *testing.B) {
 	b.Run("Summer", summer)
 	b.Run("Circuit", circuit)
 }
+
+func TestTiledTextureFormats(t *testing.T) {
+	fsys := testTileFS(t)
+
+	red := image.NewPaletted(image.Rect(0, 0, 2, 2), color.Palette{color.RGBA{0xff, 0, 0, 0xff}})
+	blue := image.NewNRGBA(image.Rect(0, 0, 2, 2))
+	for i := 0; i < len(blue.Pix); i += 4 {
+		blue.Pix[i+2], blue.Pix[i+3] = 0xff, 0x80
+	}
+	fsys["tiles/red.png"] = pngFile(t, red)
+	fsys["tiles/blue.png"] = pngFile(t, blue)
+
+	tm, err := NewTiledFS(fsys, "tiles", TiledOptions{Width: 2, Height: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for seed := int64(0); seed < 4; seed++ {
+		if !tm.Run(seed, 0) {
+			t.Fatal("CONTRADICTION")
+		}
+
+		img, err := tm.Graphics()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		switch c := img.At(0, 0).(color.RGBA); c {
+		case color.RGBA{0xff, 0, 0, 0xff}, color.RGBA{0, 0, 0x80, 0x80}:
+		default:
+			t.Errorf("seed %d: unexpected colour %v", seed, c)
+		}
+	}
+
+	fsys["tiles/blue.png"] = pngFile(t, solidTile(3, color.White))
+	tm, err = NewTiledFS(fsys, "tiles", TiledOptions{Width: 2, Height: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tm.Pin(EdgeRule{Edges: EdgeAll, Allowed: []int{1}})
+	tm.Run(testSeed, 0)
+	if _, err := tm.Graphics(); err == nil {
+		t.Error("expected error for mis-sized tile")
+	}
+}
+
+func TestTiledReflections(t *testing.T) {
+	// an L shape of three distinct pixels has no symmetry at all.
+	arrow := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	arrow.Set(0, 0, color.RGBA{0xff, 0, 0, 0xff})
+	arrow.Set(1, 0, color.RGBA{0, 0xff, 0, 0xff})
+	arrow.Set(0, 1, color.RGBA{0, 0, 0xff, 0xff})
+
+	fsys := fstest.MapFS{
+		"f/data.xml": &fstest.MapFile{Data: []byte(`<set size="2">
+	<tiles><tile name="arrow" symmetry="F"/></tiles>
+	<neighbors><neighbor left="arrow" right="arrow 5"/></neighbors>
+</set>`)},
+		"f/arrow.png": pngFile(t, arrow),
+	}
+
+	tm, err := NewTiledFS(fsys, "f", TiledOptions{Width: 2, Height: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tm.tiles) != 8 {
+		t.Fatalf("%d orientations, want 8", len(tm.tiles))
+	}
+
+	pix := func(o int) []byte {
+		tex, err := tm.texture(tm.tiles[o])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tex.CarTile(tm.tiles[o].cardinality)
+	}
+
+	// the action map must agree with the cached textures: action 1 is a
+	// quarter turn and action 4 a mirror image of the same orientation.
+	seen := make(map[string]bool)
+	for o := range tm.tiles {
+		seen[string(pix(o))] = true
+
+		base := &texture{Size: 2, cardCache: [8][]uint8{pix(o)}}
+		if got, want := pix(tm.action[o][1]), base.CarTile(1); !bytes.Equal(got, want) {
+			t.Errorf("orientation %d: rotation is %v, want %v", o, got, want)
+		}
+		if got, want := pix(tm.action[o][4]), base.CarTile(4); !bytes.Equal(got, want) {
+			t.Errorf("orientation %d: reflection is %v, want %v", o, got, want)
+		}
+	}
+	if len(seen) != 8 {
+		t.Errorf("%d distinct orientations, want 8", len(seen))
+	}
+}
+
+func TestTiledMixedPeriodicity(t *testing.T) {
+	// red and blue alternate like a checkerboard, which cannot wrap around
+	// an odd length.
+	ts := &config.TileSet{Size: 1}
+	ts.AddTile("red", "X", 0, solidTile(1, color.RGBA{0xff, 0, 0, 0xff}))
+	ts.AddTile("blue", "X", 0, solidTile(1, color.RGBA{0, 0, 0xff, 0xff}))
+	ts.AddNeighbor("red", "blue")
+
+	for _, tc := range []struct {
+		periodic Periodicity
+		want     bool
+	}{
+		{Periodicity{true, false}, true},
+		{Periodicity{false, true}, false},
+		{Periodicity{false, false}, true},
+	} {
+		tm, err := NewTiledFromSet(ts, TiledOptions{Width: 4, Height: 3, Periodic: tc.periodic})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := tm.Run(testSeed, 0); got != tc.want {
+			t.Errorf("periodic %v: Run = %v, want %v", tc.periodic, got, tc.want)
+		}
+	}
+}
+
+func TestTiledIndices(t *testing.T) {
+	ts := &config.TileSet{Size: 1}
+	ts.AddTile("corner", "L", 0, solidTile(1, color.White))
+	ts.AddTile("empty", "X", 0, solidTile(1, color.Black))
+	ts.AddNeighbor("empty", "empty")
+
+	tm, err := NewTiledFromSet(ts, TiledOptions{Width: 2, Height: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := tm.Indices("corner, empty, corner 1, corner 3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := tm.firstOccurrence["corner"]
+	want := []int{0, 1, 2, 3, 4, tm.action[first][1], tm.action[first][3]}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Indices = %v, want %v", got, want)
+	}
+
+	// an L tile has orientations 0 to 3 only, as ValidateTileset agrees.
+	for _, spec := range []string{"corner -1", "corner 4", "corner 5", "corner 8", "corner x", "road"} {
+		if _, err := tm.Indices(spec); err == nil {
+			t.Errorf("Indices(%q) accepted", spec)
+		}
+	}
+}
+
+func TestTiledAtlas(t *testing.T) {
+	// two 2x2 cells with a one pixel margin and spacing of grey.
+	atlas := solidTile(7, color.Gray{0x80})
+	for y := 1; y < 3; y++ {
+		for x := 1; x < 3; x++ {
+			atlas.Set(x, y, color.RGBA{0xff, 0, 0, 0xff})
+			atlas.Set(x+3, y, color.RGBA{0, 0, 0xff, 0xff})
+		}
+	}
+
+	fsys := fstest.MapFS{
+		"atlas/data.xml": &fstest.MapFile{Data: []byte(`<set>
+	<atlas image="atlas.png" tilesize="2" margin="1" spacing="1"/>
+	<tiles>
+		<tile name="red" symmetry="X" cell="0"/>
+		<tile name="blue" symmetry="X" cell="1"/>
+	</tiles>
+	<neighbors>
+		<neighbor left="red" right="blue"/>
+		<neighbor left="blue" right="red"/>
+	</neighbors>
+</set>`)},
+		"atlas/atlas.png": pngFile(t, atlas),
+	}
+
+	tm, err := NewTiledFS(fsys, "atlas", TiledOptions{Width: 2, Height: 2, Periodic: Periodicity{true, true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tm.Run(testSeed, 0) {
+		t.Fatal("CONTRADICTION")
+	}
+
+	img, err := tm.Graphics()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// red and blue alternate in a checkerboard.
+	want := [2]color.RGBA{{0xff, 0, 0, 0xff}, {0, 0, 0xff, 0xff}}
+	if img.At(0, 0) != want[0] {
+		want[0], want[1] = want[1], want[0]
+	}
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			if got := img.At(x, y); got != want[(x/2+y/2)%2] {
+				t.Fatalf("pixel (%d, %d) = %v, want %v", x, y, got, want[(x/2+y/2)%2])
+			}
+		}
+	}
+
+	// the atlas cells must be the size of the tiles, and a tile that is
+	// not unique has a single cell.
+	for _, data := range []string{
+		`<set size="3"><atlas image="atlas.png" tilesize="2"/><tiles><tile name="red" symmetry="X" cell="0"/></tiles></set>`,
+		`<set size="2"><atlas image="atlas.png"/><tiles><tile name="red" symmetry="X" cell="0 1"/></tiles></set>`,
+	} {
+		fsys["atlas/data.xml"] = &fstest.MapFile{Data: []byte(data)}
+		if _, err := NewTiledFS(fsys, "atlas", TiledOptions{Width: 2, Height: 2}); err == nil {
+			t.Errorf("%s accepted", data)
+		}
+	}
+}
+
+func TestTiledAutoNeighbors(t *testing.T) {
+	red, blue := color.RGBA{0xff, 0, 0, 0xff}, color.RGBA{0, 0, 0xff, 0xff}
+	edge := solidTile(2, red)
+	edge.Set(1, 0, blue)
+	edge.Set(1, 1, blue)
+
+	tiles := `<tiles>
+		<tile name="red" symmetry="X"/>
+		<tile name="dark" symmetry="X"/>
+		<tile name="blue" symmetry="X"/>
+		<tile name="edge" symmetry="F"/>
+	</tiles>`
+	fsys := fstest.MapFS{
+		"auto/data.xml": &fstest.MapFile{Data: []byte(`<set size="2" adjacency="auto">` + tiles + `</set>`)},
+		"auto/red.png":  pngFile(t, solidTile(2, red)),
+		"auto/dark.png": pngFile(t, solidTile(2, color.RGBA{0xfe, 0, 0, 0xff})),
+		"auto/blue.png": pngFile(t, solidTile(2, blue)),
+		"auto/edge.png": pngFile(t, edge),
+	}
+
+	for _, tolerance := range []int{0, 1} {
+		tm, err := NewTiledFS(fsys, "auto", TiledOptions{Width: 2, Height: 2, Tolerance: tolerance})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		o := func(name string) int { return tm.firstOccurrence[name] }
+		tests := []struct {
+			d, t1, t2 int
+			want      bool
+		}{
+			{0, o("red"), o("edge"), true},
+			{0, o("edge"), o("blue"), true},
+			{0, o("red"), o("blue"), false},
+			{1, o("edge"), o("edge"), true},
+			{1, o("red"), o("edge"), false},
+			{0, o("red"), o("dark"), tolerance > 0},
+		}
+		for _, tt := range tests {
+			if got := tm.propagator[tt.d][tt.t1][tt.t2]; got != tt.want {
+				t.Errorf("tolerance %d: propagator[%d][%s][%s] = %v, want %v",
+					tolerance, tt.d, tm.tileRef(tt.t1), tm.tileRef(tt.t2), got, tt.want)
+			}
+		}
+
+		// the exported rules must rebuild the same propagator.
+		rules, err := xml.Marshal(tm.NeighborRules())
+		if err != nil {
+			t.Fatal(err)
+		}
+		fsys["explicit/data.xml"] = &fstest.MapFile{Data: []byte(`<set size="2">` + tiles +
+			`<neighbors>` + string(rules) + `</neighbors></set>`)}
+		for _, name := range []string{"red", "dark", "blue", "edge"} {
+			fsys["explicit/"+name+".png"] = fsys["auto/"+name+".png"]
+		}
+
+		explicit, err := NewTiledFS(fsys, "explicit", TiledOptions{Width: 2, Height: 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for d := range tm.propagator {
+			for t1 := range tm.propagator[d] {
+				for t2 := range tm.propagator[d][t1] {
+					if tm.propagator[d][t1][t2] != explicit.propagator[d][t1][t2] {
+						t.Errorf("tolerance %d: exported propagator[%d][%d][%d] = %v, want %v",
+							tolerance, d, t1, t2, explicit.propagator[d][t1][t2], tm.propagator[d][t1][t2])
+					}
+				}
+			}
+		}
+	}
+}
+
+// edgeSocket labels a tile edge by its pixels read clockwise around the
+// tile, the way socket labels are read.
+func edgeSocket(img *image.RGBA, x, y, dx, dy int) string {
+	var seq, rev []byte
+	for i := 0; i < img.Rect.Dx(); i++ {
+		c := '0'
+		if img.RGBAAt(x+i*dx, y+i*dy).R == 0 {
+			c = '1'
+		}
+		seq = append(seq, byte(c))
+		rev = append([]byte{byte(c)}, rev...)
+	}
+
+	switch s, r := string(seq), string(rev); {
+	case s == r:
+		return s
+	case s < r:
+		return s + "+"
+	default:
+		return r + "-"
+	}
+}
+
+func TestTiledSockets(t *testing.T) {
+	tiles := []*image.RGBA{
+		maskTile("...", ".#.", "..."),
+		maskTile(".#.", ".#.", ".#."),
+		maskTile("#..", ".#.", "..#"),
+		maskTile("###", ".#.", "..."),
+		maskTile("#..", "#..", "###"),
+		maskTile("##.", "#..", "#.."),
+		maskTile("#..", "..#", ".##"),
+		maskTile(".##", "#..", "..."),
+	}
+
+	fsys := fstest.MapFS{}
+	var auto, sockets bytes.Buffer
+	for i, img := range tiles {
+		class, err := DetectSymmetry(img)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		name := fmt.Sprintf("t%d", i)
+		fsys["auto/"+name+".png"] = pngFile(t, img)
+		fsys["sockets/"+name+".png"] = fsys["auto/"+name+".png"]
+
+		fmt.Fprintf(&auto, "<tile name=%q symmetry=%q/>\n", name, class)
+		fmt.Fprintf(&sockets, "<tile name=%q symmetry=%q sockets=\"%s %s %s %s\"/>\n", name, class,
+			edgeSocket(img, 0, 0, 1, 0), edgeSocket(img, 2, 0, 0, 1),
+			edgeSocket(img, 2, 2, -1, 0), edgeSocket(img, 0, 2, 0, -1))
+	}
+	fsys["auto/data.xml"] = &fstest.MapFile{Data: []byte(`<set size="3" adjacency="auto"><tiles>` + auto.String() + `</tiles></set>`)}
+	fsys["sockets/data.xml"] = &fstest.MapFile{Data: []byte(`<set size="3"><tiles>` + sockets.String() + `</tiles></set>`)}
+
+	want, err := NewTiledFS(fsys, "auto", TiledOptions{Width: 2, Height: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := NewTiledFS(fsys, "sockets", TiledOptions{Width: 2, Height: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// socket labels follow the edge pixels, so both must allow the same
+	// pairs.
+	for d := range want.propagator {
+		for t1 := range want.propagator[d] {
+			for t2 := range want.propagator[d][t1] {
+				if g, w := got.propagator[d][t1][t2], want.propagator[d][t1][t2]; g != w {
+					t.Errorf("propagator[%d][%s][%s] = %v, want %v", d, got.tileRef(t1), got.tileRef(t2), g, w)
+				}
+			}
+		}
+	}
+}
+
+func TestTiledFromSet(t *testing.T) {
+	red, blue := solidTile(2, color.RGBA{0xff, 0, 0, 0xff}), solidTile(2, color.RGBA{0, 0, 0xff, 0xff})
+
+	ts := &config.TileSet{Size: 2}
+	ts.AddTile("red", "X", 0, red)
+	ts.AddTile("blue", "X", 2, blue)
+	ts.AddNeighbor("red", "blue")
+	ts.AddNeighbor("blue", "red")
+	ts.AddSubset("only red", "red")
+
+	tm, err := NewTiledFromSet(ts, TiledOptions{Width: 2, Height: 2, Periodic: Periodicity{true, true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tm.Run(testSeed, 0) {
+		t.Fatal("CONTRADICTION")
+	}
+	img, err := tm.Graphics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a, b := img.At(0, 0), img.At(2, 0); a == b {
+		t.Errorf("neighbours have the same colour %v", a)
+	}
+
+	var buf bytes.Buffer
+	if err := ts.WriteXML(&buf); err != nil {
+		t.Fatal(err)
+	}
+	want := xml.Header + `<set size="2">
+	<tiles>
+		<tile name="red" symmetry="X"></tile>
+		<tile name="blue" symmetry="X" weight="2"></tile>
+	</tiles>
+	<neighbors>
+		<neighbor left="red" right="blue"></neighbor>
+		<neighbor left="blue" right="red"></neighbor>
+	</neighbors>
+	<subsets>
+		<subset name="only red">
+			<tile name="red"></tile>
+		</subset>
+	</subsets>
+</set>
+`
+	if buf.String() != want {
+		t.Errorf("WriteXML:\n%s\nwant:\n%s", buf.String(), want)
+	}
+
+	// the written set loads back with the images saved next to it.
+	fsys := fstest.MapFS{
+		"set/data.xml": &fstest.MapFile{Data: buf.Bytes()},
+		"set/red.png":  pngFile(t, red),
+		"set/blue.png": pngFile(t, blue),
+	}
+	loaded, err := NewTiledFS(fsys, "set", TiledOptions{Width: 2, Height: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(loaded.propagator, loaded.stationary) != fmt.Sprint(tm.propagator, tm.stationary) {
+		t.Errorf("reloaded set differs")
+	}
+
+	if _, err := NewTiledFromSet(&config.TileSet{Tiles: []config.Tile{{Name: "red"}}}, TiledOptions{}); err == nil {
+		t.Error("NewTiledFromSet accepted a tile without images")
+	}
+}
+
+func TestTiledFromSetFS(t *testing.T) {
+	ts := &config.TileSet{Size: 2}
+	ts.Tiles = []config.Tile{{Name: "ruby", Symmetry: "X", Image: "red.png"}}
+	ts.AddNeighbor("ruby", "ruby")
+
+	// the file is only found through a filesystem, and without one the
+	// tile is rejected before anything is drawn.
+	if _, err := NewTiledFromSet(ts, TiledOptions{Width: 2, Height: 2}); err == nil {
+		t.Error("NewTiledFromSet accepted a tile from a file")
+	}
+
+	tm, err := NewTiledFromSetFS(testTileFS(t), "tiles", ts, TiledOptions{Width: 2, Height: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tm.Run(testSeed, 0) {
+		t.Fatal("CONTRADICTION")
+	}
+	img, err := tm.Graphics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := color.RGBAModel.Convert(img.At(3, 3)), (color.RGBA{0xff, 0, 0, 0xff}); got != want {
+		t.Errorf("pixel (3, 3) is %v, want %v", got, want)
+	}
+}
+
+func TestTiledSubsets(t *testing.T) {
+	ts := &config.TileSet{Size: 2}
+	for _, name := range []string{"red", "green", "blue"} {
+		ts.AddTile(name, "X", 0, solidTile(2, color.White))
+		for _, other := range []string{"red", "green", "blue"} {
+			ts.AddNeighbor(name, other)
+		}
+	}
+	ts.AddSubset("warm", "red", "green")
+	ts.AddSubset("cold-ish", "green", "blue")
+	ts.Subsets[1].Tiles[1].Weight = 5
+
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{"warm", "map[green:0 red:0]"},
+		{"warm + cold-ish", "map[blue:5 green:0 red:0]"},
+		{"warm & cold-ish", "map[green:0]"},
+		{"* - warm", "map[blue:0]"},
+		{"warm - green + blue", "map[blue:0 red:0]"},
+	}
+	for _, tt := range tests {
+		set, err := ts.SubsetList(tt.expr)
+		if err != nil {
+			t.Errorf("%q: %v", tt.expr, err)
+			continue
+		}
+		if got := fmt.Sprint(set); got != tt.want {
+			t.Errorf("%q selects %s, want %s", tt.expr, got, tt.want)
+		}
+	}
+	for _, expr := range []string{"warm - warm", "hot"} {
+		if _, err := ts.SubsetList(expr); err == nil {
+			t.Errorf("%q: no error", expr)
+		}
+	}
+
+	tm, err := NewTiledFromSet(ts, TiledOptions{
+		Subset:  "cold-ish + red",
+		Weights: map[string]float64{"red": 3},
+		Width:   4,
+		Height:  4,
+		Regions: []Region{{image.Rect(0, 0, 2, 4), "blue"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fmt.Sprint(tm.names, tm.stationary), "[red green blue] [3 1 5]"; got != want {
+		t.Errorf("tiles and weights %s, want %s", got, want)
+	}
+
+	if !tm.Run(testSeed, 0) {
+		t.Fatal("CONTRADICTION")
+	}
+	for x := 0; x < 2; x++ {
+		for y := 0; y < 4; y++ {
+			if !tm.wave[x][y][tm.firstOccurrence["blue"]] {
+				t.Errorf("cell (%d, %d) is not blue", x, y)
+			}
+		}
+	}
+}
+
+func TestTiledSubsetOfUnknownTiles(t *testing.T) {
+	ts := &config.TileSet{Size: 1}
+	ts.AddTile("red", "X", 0, solidTile(1, color.White))
+	ts.AddNeighbor("red", "red")
+	ts.AddSubset("ghosts", "orange", "purple")
+
+	if _, err := NewTiledFromSet(ts, TiledOptions{Subset: "ghosts", Width: 2, Height: 2}); err == nil {
+		t.Error("a subset of unknown tiles accepted")
+	}
+	if _, err := NewTiledFromSet(&config.TileSet{Size: 1}, TiledOptions{Width: 2, Height: 2}); err == nil {
+		t.Error("a tileset without tiles accepted")
+	}
+}
+
+func TestTiledWeightedNeighbors(t *testing.T) {
+	// the share of equal horizontal neighbours in a 16x16 output.
+	same := func(weight float64) float64 {
+		ts := &config.TileSet{Size: 1}
+		ts.AddTile("red", "X", 0, solidTile(1, color.RGBA{0xff, 0, 0, 0xff}))
+		ts.AddTile("blue", "X", 0, solidTile(1, color.RGBA{0, 0, 0xff, 0xff}))
+		ts.AddNeighbor("red", "blue")
+		ts.AddNeighbor("red", "red")
+		ts.AddNeighbor("blue", "blue")
+		ts.Neighbors[1].Weight = weight
+		ts.Neighbors[2].Weight = weight
+
+		tm, err := NewTiledFromSet(ts, TiledOptions{Width: 16, Height: 16})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !tm.Run(testSeed, 0) {
+			t.Fatal("CONTRADICTION")
+		}
+
+		var n int
+		for x := 1; x < 16; x++ {
+			for y := 0; y < 16; y++ {
+				if tm.wave[x][y][0] == tm.wave[x-1][y][0] {
+					n++
+				}
+			}
+		}
+		return float64(n) / (15 * 16)
+	}
+
+	neutral, rare, often := same(0), same(0.05), same(20)
+	if !(rare < neutral && neutral < often) {
+		t.Errorf("equal neighbours: %.2f with weight 0.05, %.2f unweighted, %.2f with weight 20", rare, neutral, often)
+	}
+	if rare > 0.25 || often < 0.75 {
+		t.Errorf("weights barely matter: %.2f with weight 0.05, %.2f with weight 20", rare, often)
+	}
+}
+
+// cornerTiles draws the 16 corner tiles of two colours, tile i having the
+// colour of bit k in corner k (top left, top right, bottom right, bottom
+// left), into a 4x4 atlas and as separate images.
+func cornerTiles(t *testing.T, fsys fstest.MapFS) {
+	colours := [2]color.RGBA{{0xff, 0, 0, 0xff}, {0, 0, 0xff, 0xff}}
+	atlas := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	for i := 0; i < 16; i++ {
+		tile := image.NewRGBA(image.Rect(0, 0, 4, 4))
+		for y := 0; y < 4; y++ {
+			for x := 0; x < 4; x++ {
+				corner := [2][2]int{{0, 1}, {3, 2}}[y/2][x/2]
+				c := colours[i>>corner&1]
+				tile.SetRGBA(x, y, c)
+				atlas.SetRGBA(i%4*4+x, i/4*4+y, c)
+			}
+		}
+		fsys["tsx/"+strconv.Itoa(i)+".png"] = pngFile(t, tile)
+	}
+	fsys["tsx/atlas.png"] = pngFile(t, atlas)
+}
+
+func TestTiledTSX(t *testing.T) {
+	fsys := fstest.MapFS{}
+	cornerTiles(t, fsys)
+
+	var wang, terrain strings.Builder
+	for i := 0; i < 16; i++ {
+		c := func(k int) int { return i>>k&1 + 1 }
+		fmt.Fprintf(&wang, `<wangtile tileid="%d" wangid="0,%d,0,%d,0,%d,0,%d"/>`, i, c(1), c(2), c(3), c(0))
+		fmt.Fprintf(&terrain, `<tile id="%d" terrain="%d,%d,%d,%d"><image source="%d.png"/></tile>`,
+			i, c(0)-1, c(1)-1, c(3)-1, c(2)-1, i)
+	}
+	fsys["tsx/wang.tsx"] = &fstest.MapFile{Data: []byte(`<tileset tilewidth="4" tileheight="4" tilecount="16" columns="4">
+ <image source="atlas.png" width="16" height="16"/>
+ <wangsets>
+  <wangset name="other" type="corner"/>
+  <wangset name="corners" type="corner">
+   <wangcolor name="red"/><wangcolor name="blue"/>` + wang.String() + `
+  </wangset>
+ </wangsets>
+</tileset>`)}
+	fsys["tsx/terrain.tsx"] = &fstest.MapFile{Data: []byte(`<tileset tilewidth="4" tileheight="4">
+ <terraintypes><terrain name="red"/><terrain name="blue"/></terraintypes>` + terrain.String() + `
+</tileset>`)}
+
+	for _, name := range []string{"tsx/wang.tsx", "tsx/terrain.tsx"} {
+		tm, err := NewTiledFS(fsys, name, TiledOptions{Width: 8, Height: 8, WangSet: "corners"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(tm.tiles) != 16 {
+			t.Fatalf("%s: %d tiles, want 16", name, len(tm.tiles))
+		}
+		if !tm.Run(testSeed, 0) {
+			t.Fatalf("%s: CONTRADICTION", name)
+		}
+		img, err := tm.Graphics()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the corners of neighbouring tiles have the same colour, so
+		// the pixels either side of every tile boundary agree.
+		for y := 0; y < 32; y++ {
+			for x := 4; x < 32; x += 4 {
+				if img.At(x-1, y) != img.At(x, y) || img.At(y, x-1) != img.At(y, x) {
+					t.Fatalf("%s: colours change across the tile boundary at %d, %d", name, x, y)
+				}
+			}
+		}
+	}
+}
+
+// blendedGraphics renders tm one pixel at a time through a blender, the
+// way Graphics did before it copied collapsed tiles directly.
+func blendedGraphics(t *testing.T, tm *Tiled) *image.RGBA {
+	S := tm.tileSize
+	result := image.NewRGBA(image.Rect(0, 0, tm.FM.X*S, tm.FM.Y*S))
+	for x, col := range tm.wave {
+		for y, row := range col {
+			var amount, best int
+			var lambda float64
+			for s, on := range row {
+				if on {
+					if amount == 0 || tm.stationary[s] > tm.stationary[best] {
+						best = s
+					}
+					amount++
+					lambda += tm.stationary[s]
+				}
+			}
+
+			buf := make([]blender, S*S)
+			for i := range buf {
+				buf[i].linear = tm.Blend == BlendLinear
+			}
+			if !tm.black || amount != len(row) {
+				for s, on := range row {
+					if !on || (tm.Blend == BlendMostProbable && s != best) {
+						continue
+					}
+					weight := uint64(tm.stationary[s]/lambda*0x10000 + 0.5)
+					tex, err := tm.texture(tm.tiles[s])
+					if err != nil {
+						t.Fatal(err)
+					}
+					pix := tex.CarTile(tm.tiles[s].cardinality)
+					for i := range buf {
+						p := pix[i*4:]
+						buf[i].add(uint32(p[0])*0x101, uint32(p[1])*0x101, uint32(p[2])*0x101, uint32(p[3])*0x101, weight)
+					}
+				}
+			}
+			for i := range buf {
+				result.SetRGBA(x*S+i%S, y*S+i/S, buf[i].rgba())
+			}
+		}
+	}
+	return result
+}
+
+func TestTiledGraphicsIdentical(t *testing.T) {
+	// a faint arrow without symmetry, whose colours linear blending
+	// rounds, next to an opaque one.
+	arrow := func(a uint8) *image.RGBA {
+		img := solidTile(3, color.NRGBA{0x20, 0x40, 0x60, a})
+		img.Set(0, 0, color.NRGBA{0xff, 0x10, 0, a})
+		img.Set(1, 0, color.NRGBA{0, 0xf0, 0x33, a / 2})
+		img.Set(0, 1, color.NRGBA{0x07, 0, 0xfe, a})
+		return img
+	}
+
+	ts := &config.TileSet{Size: 3}
+	ts.Tiles = []config.Tile{
+		{Name: "glass", Symmetry: "F", Weight: 1, Sockets: "a a a a", Images: []image.Image{arrow(0x08)}},
+		{Name: "stone", Symmetry: "F", Weight: 2, Sockets: "a a a a", Images: []image.Image{arrow(0xff)}},
+	}
+
+	for _, blend := range []Blend{BlendAverage, BlendMostProbable, BlendLinear} {
+		for _, limit := range []int{5, 0} {
+			tm, err := NewTiledFromSet(ts, TiledOptions{Width: 6, Height: 5})
+			if err != nil {
+				t.Fatal(err)
+			}
+			tm.Blend = blend
+			if !tm.Run(testSeed, limit) && limit == 0 {
+				t.Fatal("CONTRADICTION")
+			}
+
+			img, err := tm.Graphics()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := img.(*image.RGBA), blendedGraphics(t, tm); !bytes.Equal(got.Pix, want.Pix) {
+				t.Errorf("blend %d, limit %d: Graphics differs from the blended rendering", blend, limit)
+			}
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package bohm
+
+import (
+	"bytes"
+	"image"
+	"io/fs"
+	"path"
+
+	"vallon.me/bohm/config"
+)
+
+// symmetryClasses lists the tile symmetry classes from most to least
+// symmetric.
+var symmetryClasses = []string{"X", "I", "\\", "T", "L", "F"}
+
+// symmetryClass returns the number of distinct orientations of a tile
+// class, and the action of a quarter turn (a) and a mirror image (b) on
+// them. Unknown classes are treated as X.
+func symmetryClass(class string) (cardinality int, a, b func(int) int) {
+	switch class {
+	case "L":
+		cardinality = 4
+		a = func(i int) int { return (i + 1) % 4 }
+		b = func(i int) int {
+			if i%2 == 0 {
+				return i + 1
+			}
+			return i - 1
+		}
+	case "T":
+		cardinality = 4
+		a = func(i int) int { return (i + 1) % 4 }
+		b = func(i int) int {
+			if i%2 == 0 {
+				return i
+			}
+			return 4 - i
+		}
+	case "I":
+		cardinality = 2
+		a = func(i int) int { return 1 - i }
+		b = func(i int) int { return i }
+	case "\\":
+		cardinality = 2
+		a = func(i int) int { return 1 - i }
+		b = func(i int) int { return 1 - i }
+	case "F":
+		// no symmetry: four rotations followed by their mirror images.
+		cardinality = 8
+		a = func(i int) int {
+			if i < 4 {
+				return (i + 1) % 4
+			}
+			return 4 + (i+3)%4
+		}
+		b = func(i int) int {
+			if i < 4 {
+				return i + 4
+			}
+			return i - 4
+		}
+	default:
+		cardinality = 1
+		a = func(i int) int { return i }
+		b = func(i int) int { return i }
+	}
+	return
+}
+
+// DetectSymmetry returns the most symmetric class whose orientations
+// agree with the rotations and reflections of a square tile image.
+func DetectSymmetry(img image.Image) (string, error) {
+	pix, err := tilePix(img, img.Bounds().Dx())
+	if err != nil {
+		return "", err
+	}
+	t := &texture{Size: img.Bounds().Dx(), cardCache: [8][]uint8{pix}}
+	return detectSymmetry(t), nil
+}
+
+func detectSymmetry(t *texture) string {
+	turn := func(pix []uint8, k int) []uint8 {
+		return (&texture{Size: t.Size, cardCache: [8][]uint8{pix}}).CarTile(k)
+	}
+
+	// a class fits if turning or mirroring each of its orientations gives
+	// the orientation its action map names.
+	for _, class := range symmetryClasses {
+		cardinality, a, b := symmetryClass(class)
+
+		fits := true
+		for o := 0; o < cardinality && fits; o++ {
+			fits = bytes.Equal(turn(t.CarTile(o), 1), t.CarTile(a(o))) &&
+				bytes.Equal(turn(t.CarTile(o), 4), t.CarTile(b(o)))
+		}
+		if fits {
+			return class
+		}
+	}
+	return "F"
+}
+
+// TileSymmetry compares the declared symmetry class of a tile with the one
+// detected from its image.
+type TileSymmetry struct {
+	Name               string
+	Declared, Detected string
+}
+
+// DetectTileSymmetry detects the symmetry class of every tile in the
+// tileset rooted at root within fsys. Unique tilesets have an image per
+// orientation and cannot be checked.
+func DetectTileSymmetry(fsys fs.FS, root string) ([]TileSymmetry, error) {
+	ts, err := config.ReadTileDataFS(fsys, path.Join(root, "data.xml"))
+	if err != nil {
+		return nil, err
+	}
+	if ts.Size == 0 {
+		ts.Size = 16
+	}
+	if ts.Unique {
+		return nil, nil
+	}
+	return (&Tiled{tileSize: ts.Size}).detectTileSymmetry(fsys, root, ts)
+}
+
+func (tm *Tiled) detectTileSymmetry(fsys fs.FS, root string, ts *config.TileSet) ([]TileSymmetry, error) {
+	var result []TileSymmetry
+	for _, tile := range ts.Tiles {
+		defs, err := tileDefs(fsys, root, ts, tile, 1)
+		if err != nil {
+			return nil, err
+		}
+		def := defs[0]
+		def.size = tm.tileSize
+
+		t, err := tm.texture(def)
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, TileSymmetry{
+			Name:     tile.Name,
+			Declared: tile.Symmetry,
+			Detected: detectSymmetry(t),
+		})
+	}
+	return result, nil
+}
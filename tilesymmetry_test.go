@@ -0,0 +1,93 @@
+package bohm
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// maskTile draws a square tile with black where the rows have a '#'.
+func maskTile(rows ...string) *image.RGBA {
+	img := solidTile(len(rows), color.White)
+	for y, row := range rows {
+		for x, c := range row {
+			if c == '#' {
+				img.Set(x, y, color.Black)
+			}
+		}
+	}
+	return img
+}
+
+func TestDetectSymmetry(t *testing.T) {
+	tests := []struct {
+		want string
+		img  *image.RGBA
+	}{
+		{"X", maskTile("...", ".#.", "...")},
+		{"I", maskTile(".#.", ".#.", ".#.")},
+		{"\\", maskTile("#..", ".#.", "..#")},
+		{"T", maskTile("###", ".#.", "...")},
+		{"L", maskTile("#..", "#..", "###")},
+		{"F", maskTile("##.", "#..", "#..")},
+	}
+	for _, tt := range tests {
+		got, err := DetectSymmetry(tt.img)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("DetectSymmetry(%s) = %s, want %s", tt.want, got, tt.want)
+		}
+	}
+
+	if _, err := DetectSymmetry(image.NewRGBA(image.Rect(0, 0, 2, 3))); err == nil {
+		t.Error("DetectSymmetry accepted a non-square image")
+	}
+}
+
+func TestTiledDetectSymmetry(t *testing.T) {
+	fsys := fstest.MapFS{
+		"sym/data.xml": &fstest.MapFile{Data: []byte(`<set size="3">
+	<tiles>
+		<tile name="line"/>
+		<tile name="corner" symmetry="T"/>
+	</tiles>
+</set>`)},
+		"sym/line.png":   pngFile(t, maskTile(".#.", ".#.", ".#.")),
+		"sym/corner.png": pngFile(t, maskTile("#..", "#..", "###")),
+	}
+
+	var warnings []string
+	tm, err := NewTiledFS(fsys, "sym", TiledOptions{
+		Width:          2,
+		Height:         2,
+		DetectSymmetry: true,
+		Warnf: func(format string, v ...interface{}) {
+			warnings = append(warnings, fmt.Sprintf(format, v...))
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the line gets two orientations, the corner keeps its declared four.
+	if len(tm.tiles) != 6 {
+		t.Errorf("%d orientations, want 6", len(tm.tiles))
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], `"corner"`) {
+		t.Errorf("warnings = %q, want one for corner", warnings)
+	}
+
+	got, err := DetectTileSymmetry(fsys, "sym")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []TileSymmetry{{"line", "", "I"}, {"corner", "T", "L"}}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("DetectTileSymmetry = %v, want %v", got, want)
+	}
+}
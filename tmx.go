@@ -0,0 +1,321 @@
+package bohm
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// MapFormat selects the file format of maps for the Tiled map editor.
+type MapFormat int
+
+const (
+	MapTMX MapFormat = iota
+	MapJSON
+)
+
+// ParseMapFormat parses "tmx" or "json" ("tmj").
+func ParseMapFormat(s string) (MapFormat, error) {
+	switch strings.ToLower(s) {
+	case "tmx":
+		return MapTMX, nil
+	case "json", "tmj":
+		return MapJSON, nil
+	}
+	return 0, fmt.Errorf("bohm: unknown map format %q", s)
+}
+
+// MapExt and TilesetExt return the file extensions of the format's maps and
+// tilesets.
+func (f MapFormat) MapExt() string {
+	if f == MapJSON {
+		return ".tmj"
+	}
+	return ".tmx"
+}
+
+func (f MapFormat) TilesetExt() string {
+	if f == MapJSON {
+		return ".tsj"
+	}
+	return ".tsx"
+}
+
+// gid flags of the Tiled map editor, applied diagonal flip first.
+const (
+	gidFlipH = 0x80000000
+	gidFlipV = 0x40000000
+	gidFlipD = 0x20000000
+)
+
+// orientationFlags turns the CarTile orientations into gid flags: quarter
+// turns counter-clockwise, then a mirror image left to right.
+var orientationFlags = [8]uint32{
+	0,
+	gidFlipD | gidFlipV,
+	gidFlipH | gidFlipV,
+	gidFlipD | gidFlipH,
+	gidFlipH,
+	gidFlipD | gidFlipV | gidFlipH,
+	gidFlipV,
+	gidFlipD,
+}
+
+// mapTiles lists the tileset images and the local tile id of every
+// orientation. An atlas is a single image whose cells are the tile ids.
+func (tm *Tiled) mapTiles() (images []string, ids []int, err error) {
+	index := make(map[string]int)
+	for _, def := range tm.tiles {
+		switch {
+		case def.img != nil:
+			return nil, nil, fmt.Errorf("bohm: tile %s has no image file", def.name)
+		case def.atlas != nil:
+			if len(images) == 0 {
+				images = append(images, def.name)
+			}
+			ids = append(ids, def.cell)
+		default:
+			id, ok := index[def.name]
+			if !ok {
+				id = len(images)
+				index[def.name] = id
+				images = append(images, def.name)
+			}
+			ids = append(ids, id)
+		}
+	}
+	return images, ids, nil
+}
+
+// gids returns the global tile ids of the output in row-major order, with
+// 0 for cells that have not collapsed to a single tile.
+func (tm *Tiled) gids(ids []int) []uint32 {
+	gids := make([]uint32, 0, tm.FM.X*tm.FM.Y)
+	for y := 0; y < tm.FM.Y; y++ {
+		for x := 0; x < tm.FM.X; x++ {
+			tile := -1
+			for t, on := range tm.wave[x][y] {
+				if on {
+					if tile >= 0 {
+						tile = -1
+						break
+					}
+					tile = t
+				}
+			}
+
+			var gid uint32
+			if tile >= 0 {
+				gid = uint32(ids[tile]+1) | orientationFlags[tm.tiles[tile].cardinality]
+			}
+			gids = append(gids, gid)
+		}
+	}
+	return gids
+}
+
+type tmxMap struct {
+	XMLName      xml.Name `xml:"map" json:"-"`
+	Type         string   `xml:"-" json:"type"`
+	Version      string   `xml:"version,attr" json:"version"`
+	Orientation  string   `xml:"orientation,attr" json:"orientation"`
+	RenderOrder  string   `xml:"renderorder,attr" json:"renderorder"`
+	Width        int      `xml:"width,attr" json:"width"`
+	Height       int      `xml:"height,attr" json:"height"`
+	TileWidth    int      `xml:"tilewidth,attr" json:"tilewidth"`
+	TileHeight   int      `xml:"tileheight,attr" json:"tileheight"`
+	Infinite     int      `xml:"infinite,attr" json:"-"`
+	NextLayerID  int      `xml:"nextlayerid,attr" json:"nextlayerid"`
+	NextObjectID int      `xml:"nextobjectid,attr" json:"nextobjectid"`
+
+	Tilesets []tmxTilesetRef `xml:"tileset" json:"tilesets"`
+	Layers   []tmxLayer      `xml:"layer" json:"layers"`
+}
+
+type tmxTilesetRef struct {
+	FirstGID int    `xml:"firstgid,attr" json:"firstgid"`
+	Source   string `xml:"source,attr" json:"source"`
+}
+
+type tmxLayer struct {
+	Type    string   `xml:"-" json:"type"`
+	ID      int      `xml:"id,attr" json:"id"`
+	Name    string   `xml:"name,attr" json:"name"`
+	Width   int      `xml:"width,attr" json:"width"`
+	Height  int      `xml:"height,attr" json:"height"`
+	Opacity float64  `xml:"-" json:"opacity"`
+	Visible bool     `xml:"-" json:"visible"`
+	Data    *tmxData `xml:"data" json:"-"`
+	GIDs    []uint32 `xml:"-" json:"data"`
+}
+
+type tmxData struct {
+	Encoding string `xml:"encoding,attr"`
+	CSV      string `xml:",innerxml"`
+}
+
+// WriteMap writes the output as a single layer map in the given format,
+// using the tileset file written by WriteMapTileset at tileset. Cells
+// that have not collapsed are left empty.
+func (tm *Tiled) WriteMap(w io.Writer, format MapFormat, tileset string) error {
+	_, ids, err := tm.mapTiles()
+	if err != nil {
+		return err
+	}
+	gids := tm.gids(ids)
+
+	m := tmxMap{
+		Type:         "map",
+		Version:      "1.10",
+		Orientation:  "orthogonal",
+		RenderOrder:  "right-down",
+		Width:        tm.FM.X,
+		Height:       tm.FM.Y,
+		TileWidth:    tm.tileSize,
+		TileHeight:   tm.tileSize,
+		NextLayerID:  2,
+		NextObjectID: 1,
+		Tilesets:     []tmxTilesetRef{{1, tileset}},
+		Layers: []tmxLayer{{
+			Type:    "tilelayer",
+			ID:      1,
+			Name:    "Tile Layer 1",
+			Width:   tm.FM.X,
+			Height:  tm.FM.Y,
+			Opacity: 1,
+			Visible: true,
+			GIDs:    gids,
+		}},
+	}
+
+	if format == MapJSON {
+		return writeJSON(w, m)
+	}
+
+	var csv strings.Builder
+	for i, gid := range gids {
+		if i%tm.FM.X == 0 {
+			csv.WriteByte('\n')
+		}
+		csv.WriteString(strconv.FormatUint(uint64(gid), 10))
+		if i < len(gids)-1 {
+			csv.WriteByte(',')
+		}
+	}
+	csv.WriteByte('\n')
+	m.Layers[0].Data = &tmxData{"csv", csv.String()}
+
+	return writeXML(w, m)
+}
+
+type tmxTileset struct {
+	XMLName    xml.Name `xml:"tileset" json:"-"`
+	Type       string   `xml:"-" json:"type"`
+	Version    string   `xml:"version,attr" json:"version"`
+	Name       string   `xml:"name,attr" json:"name"`
+	TileWidth  int      `xml:"tilewidth,attr" json:"tilewidth"`
+	TileHeight int      `xml:"tileheight,attr" json:"tileheight"`
+	Spacing    int      `xml:"spacing,attr,omitempty" json:"spacing"`
+	Margin     int      `xml:"margin,attr,omitempty" json:"margin"`
+	TileCount  int      `xml:"tilecount,attr" json:"tilecount"`
+	Columns    int      `xml:"columns,attr" json:"columns"`
+
+	// an atlas is a single image, otherwise every tile has its own.
+	Image       *tmxImage `xml:"image" json:"-"`
+	ImagePath   string    `xml:"-" json:"image,omitempty"`
+	ImageWidth  int       `xml:"-" json:"imagewidth,omitempty"`
+	ImageHeight int       `xml:"-" json:"imageheight,omitempty"`
+
+	Grid  *tmxGrid  `xml:"grid" json:"grid,omitempty"`
+	Tiles []tmxTile `xml:"tile" json:"tiles,omitempty"`
+}
+
+type tmxImage struct {
+	Source string `xml:"source,attr"`
+	Width  int    `xml:"width,attr"`
+	Height int    `xml:"height,attr"`
+}
+
+type tmxGrid struct {
+	Orientation string `xml:"orientation,attr" json:"orientation"`
+	Width       int    `xml:"width,attr" json:"width"`
+	Height      int    `xml:"height,attr" json:"height"`
+}
+
+type tmxTile struct {
+	ID          int       `xml:"id,attr" json:"id"`
+	Image       *tmxImage `xml:"image" json:"-"`
+	ImagePath   string    `xml:"-" json:"image"`
+	ImageWidth  int       `xml:"-" json:"imagewidth"`
+	ImageHeight int       `xml:"-" json:"imageheight"`
+}
+
+// WriteMapTileset writes the tileset of the maps written by WriteMap. The
+// tile images are referenced by their path within the model's file system,
+// prefixed with imageDir.
+func (tm *Tiled) WriteMapTileset(w io.Writer, format MapFormat, name, imageDir string) error {
+	images, _, err := tm.mapTiles()
+	if err != nil {
+		return err
+	}
+
+	ts := tmxTileset{
+		Type:       "tileset",
+		Version:    "1.10",
+		Name:       name,
+		TileWidth:  tm.tileSize,
+		TileHeight: tm.tileSize,
+	}
+
+	if def := tm.tiles[0]; def.atlas != nil {
+		if _, err := tm.texture(def); err != nil {
+			return err
+		}
+		b := tm.atlases[def.name].Bounds()
+		step := tm.tileSize + def.atlas.Spacing
+
+		ts.Spacing, ts.Margin = def.atlas.Spacing, def.atlas.Margin
+		ts.Columns = (b.Dx() - 2*def.atlas.Margin + def.atlas.Spacing) / step
+		ts.TileCount = ts.Columns * ((b.Dy() - 2*def.atlas.Margin + def.atlas.Spacing) / step)
+
+		img := tmxImage{path.Join(imageDir, def.name), b.Dx(), b.Dy()}
+		ts.Image, ts.ImagePath, ts.ImageWidth, ts.ImageHeight = &img, img.Source, img.Width, img.Height
+	} else {
+		ts.TileCount = len(images)
+		ts.Grid = &tmxGrid{"orthogonal", 1, 1}
+		for id, name := range images {
+			img := tmxImage{path.Join(imageDir, name), tm.tileSize, tm.tileSize}
+			ts.Tiles = append(ts.Tiles, tmxTile{id, &img, img.Source, img.Width, img.Height})
+		}
+	}
+
+	if format == MapJSON {
+		return writeJSON(w, ts)
+	}
+	return writeXML(w, ts)
+}
+
+func writeXML(w io.Writer, v interface{}) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+
+	enc := xml.NewEncoder(w)
+	enc.Indent("", " ")
+	if err := enc.Encode(v); err != nil {
+		return err
+	}
+
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func writeJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", " ")
+	return enc.Encode(v)
+}
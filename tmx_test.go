@@ -0,0 +1,141 @@
+package bohm
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"image"
+	"image/color"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// flipTile applies the gid flags of the Tiled map editor to a tile.
+func flipTile(img image.Image, gid uint32) *image.RGBA {
+	S := img.Bounds().Dx()
+	out := image.NewRGBA(image.Rect(0, 0, S, S))
+	for y := 0; y < S; y++ {
+		for x := 0; x < S; x++ {
+			sx, sy := x, y
+			if gid&gidFlipV != 0 {
+				sy = S - 1 - sy
+			}
+			if gid&gidFlipH != 0 {
+				sx = S - 1 - sx
+			}
+			if gid&gidFlipD != 0 {
+				sx, sy = sy, sx
+			}
+			out.Set(x, y, img.At(sx, sy))
+		}
+	}
+	return out
+}
+
+func TestTiledWriteMap(t *testing.T) {
+	arrow := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	arrow.Set(0, 0, color.RGBA{0xff, 0, 0, 0xff})
+	arrow.Set(1, 0, color.RGBA{0, 0xff, 0, 0xff})
+	arrow.Set(0, 1, color.RGBA{0, 0, 0xff, 0xff})
+
+	fsys := fstest.MapFS{
+		"f/data.xml": &fstest.MapFile{Data: []byte(`<set size="2">
+	<tiles><tile name="arrow" symmetry="F" sockets="a a a a"/></tiles>
+</set>`)},
+		"f/arrow.png": pngFile(t, arrow),
+	}
+
+	tm, err := NewTiledFS(fsys, "f", TiledOptions{Width: 6, Height: 6})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tm.Run(testSeed, 0) {
+		t.Fatal("CONTRADICTION")
+	}
+	out, err := tm.Graphics()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := tm.WriteMap(&buf, MapTMX, "f.tsx"); err != nil {
+		t.Fatal(err)
+	}
+	var m struct {
+		Tileset struct {
+			Source string `xml:"source,attr"`
+		} `xml:"tileset"`
+		Data string `xml:"layer>data"`
+	}
+	if err := xml.Unmarshal(buf.Bytes(), &m); err != nil {
+		t.Fatal(err)
+	}
+	if m.Tileset.Source != "f.tsx" {
+		t.Errorf("tileset source %q", m.Tileset.Source)
+	}
+
+	var gids []uint32
+	for _, f := range strings.Split(strings.TrimSpace(m.Data), ",") {
+		gid, err := strconv.ParseUint(strings.TrimSpace(f), 10, 32)
+		if err != nil {
+			t.Fatal(err)
+		}
+		gids = append(gids, uint32(gid))
+	}
+
+	// every cell must look like the tileset image under its flags.
+	seen := make(map[uint32]bool)
+	for i, gid := range gids {
+		if id := gid &^ (gidFlipH | gidFlipV | gidFlipD); id != 1 {
+			t.Fatalf("cell %d has tile id %d", i, id)
+		}
+		seen[gid] = true
+
+		x, y := i%6*2, i/6*2
+		want := flipTile(arrow, gid)
+		for dy := 0; dy < 2; dy++ {
+			for dx := 0; dx < 2; dx++ {
+				if got := out.At(x+dx, y+dy); got != want.At(dx, dy) {
+					t.Fatalf("cell %d with flags %#x: pixel (%d, %d) is %v, want %v", i, gid>>29, dx, dy, got, want.At(dx, dy))
+				}
+			}
+		}
+	}
+	if len(seen) < 4 {
+		t.Errorf("only %d orientations used", len(seen))
+	}
+
+	buf.Reset()
+	if err := tm.WriteMap(&buf, MapJSON, "f.tsj"); err != nil {
+		t.Fatal(err)
+	}
+	var j struct {
+		Layers []struct {
+			Data []uint32
+		}
+	}
+	if err := json.Unmarshal(buf.Bytes(), &j); err != nil {
+		t.Fatal(err)
+	}
+	if len(j.Layers) != 1 || fmtGids(j.Layers[0].Data) != fmtGids(gids) {
+		t.Errorf("json layers %v, want %v", j.Layers, gids)
+	}
+
+	buf.Reset()
+	if err := tm.WriteMapTileset(&buf, MapTMX, "f", "../samples"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `<image source="../samples/f/arrow.png" width="2" height="2">`) {
+		t.Errorf("tileset does not reference the tile image:\n%s", buf.String())
+	}
+}
+
+func fmtGids(gids []uint32) string {
+	var s []string
+	for _, g := range gids {
+		s = append(s, strconv.FormatUint(uint64(g), 10))
+	}
+	return strings.Join(s, ",")
+}
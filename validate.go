@@ -0,0 +1,132 @@
+package bohm
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+
+	"vallon.me/bohm/config"
+)
+
+// TilesetProblem is a mistake found in a tileset definition. Line is the
+// line of data.xml it concerns, or 0 for the tileset as a whole.
+type TilesetProblem struct {
+	Line int
+	Msg  string
+}
+
+func (p TilesetProblem) String() string {
+	if p.Line == 0 {
+		return "data.xml: " + p.Msg
+	}
+	return fmt.Sprintf("data.xml:%d: %s", p.Line, p.Msg)
+}
+
+// directionNames names the side of a tile that propagator[d] looks at.
+var directionNames = [4]string{"right", "top", "left", "bottom"}
+
+// ValidateTileset checks the tileset rooted at root within fsys for
+// unknown tile names and orientations, subsets of missing tiles, missing
+// or mis-sized images and tiles that cannot have any neighbour on some
+// side. It only returns an error if data.xml cannot be read.
+func ValidateTileset(fsys fs.FS, root string) ([]TilesetProblem, error) {
+	ts, err := config.ReadTileDataFS(fsys, path.Join(root, "data.xml"))
+	if err != nil {
+		return nil, err
+	}
+
+	var problems []TilesetProblem
+	report := func(line int, format string, v ...interface{}) {
+		problems = append(problems, TilesetProblem{line, fmt.Sprintf(format, v...)})
+	}
+
+	lines := make(map[string]int)
+	cardinality := make(map[string]int)
+	for _, tile := range ts.Tiles {
+		if _, ok := lines[tile.Name]; ok {
+			report(tile.Line, "duplicate tile %q", tile.Name)
+		}
+		lines[tile.Name] = tile.Line
+		cardinality[tile.Name], _, _ = symmetryClass(tile.Symmetry)
+	}
+
+	for _, ss := range ts.Subsets {
+		for _, tile := range ss.Tiles {
+			if _, ok := lines[tile.Name]; !ok {
+				report(tile.Line, "subset %q has unknown tile %q", ss.Name, tile.Name)
+			}
+		}
+	}
+
+	// the model is built from the rules that passed, to check the rest.
+	cfg := *ts
+	cfg.Neighbors = nil
+	for _, n := range ts.Neighbors {
+		ok := true
+		for _, ref := range [2]string{n.Left, n.Right} {
+			name, k, err := parseTileRef(ref)
+			if _, known := lines[name]; err == nil && !known {
+				err = fmt.Errorf("unknown tile %q", name)
+			} else if err == nil {
+				err = checkOrientation(name, k, cardinality[name])
+			}
+			if err != nil {
+				report(n.Line, "%v", err)
+				ok = false
+			}
+		}
+		if ok {
+			cfg.Neighbors = append(cfg.Neighbors, n)
+		}
+	}
+
+	// images are checked before the adjacency, which may need them.
+	cfg.Adjacency = ""
+	tm, err := newTiled(fsys, root, &cfg, TiledOptions{Width: 1, Height: 1})
+	if err != nil {
+		report(0, "%v", err)
+		return problems, nil
+	}
+
+	var broken bool
+	checked := make(map[string]bool)
+	for t, def := range tm.tiles {
+		if checked[def.key()] {
+			continue
+		}
+		checked[def.key()] = true
+
+		if _, err := tm.texture(def); err != nil {
+			report(lines[tm.names[t]], "%v", err)
+			broken = true
+		}
+	}
+	if broken {
+		return problems, nil
+	}
+
+	if ts.Adjacency != "" {
+		cfg.Adjacency = ts.Adjacency
+		if tm, err = newTiled(fsys, root, &cfg, TiledOptions{Width: 1, Height: 1}); err != nil {
+			report(0, "%v", err)
+			return problems, nil
+		}
+	}
+
+	// the other orientations of a tile are turned or mirrored copies of the
+	// first, so it is enough to check that one.
+	for _, tile := range ts.Tiles {
+		t := tm.firstOccurrence[tile.Name]
+		for d, side := range directionNames {
+			var found bool
+			for _, ok := range tm.propagator[d][t] {
+				found = found || ok
+			}
+			if !found {
+				report(tile.Line, "tile %q has no neighbour on its %s", tile.Name, side)
+			}
+		}
+	}
+
+	return problems, nil
+}
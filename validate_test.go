@@ -0,0 +1,117 @@
+package bohm
+
+import (
+	"fmt"
+	"image/color"
+	"sort"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestValidateTileset(t *testing.T) {
+	fsys := fstest.MapFS{
+		"bad/data.xml": &fstest.MapFile{Data: []byte(`<set size="2">
+	<tiles>
+		<tile name="red" symmetry="X"/>
+		<tile name="blue" symmetry="I"/>
+		<tile name="green" symmetry="X"/>
+		<tile name="small" symmetry="X"/>
+	</tiles>
+	<neighbors>
+		<neighbor left="red" right="red"/>
+		<neighbor left="red" right="bleu"/>
+		<neighbor left="blue 2" right="red"/>
+		<neighbor left="blue 1" right="blue 1"/>
+	</neighbors>
+	<subsets>
+		<subset name="warm">
+			<tile name="red"/>
+			<tile name="orange"/>
+		</subset>
+	</subsets>
+</set>`)},
+		"bad/red.png":   pngFile(t, solidTile(2, color.RGBA{0xff, 0, 0, 0xff})),
+		"bad/blue.png":  pngFile(t, solidTile(2, color.RGBA{0, 0, 0xff, 0xff})),
+		"bad/small.png": pngFile(t, solidTile(1, color.RGBA{0, 0, 0xff, 0xff})),
+	}
+
+	problems, err := ValidateTileset(fsys, "bad")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rules := []string{
+		`data.xml:17: subset "warm" has unknown tile "orange"`,
+		`data.xml:10: unknown tile "bleu"`,
+		`data.xml:11: orientation 2 of tile "blue" is out of range, it has 2`,
+	}
+	want := append(rules[:len(rules):len(rules)],
+		`data.xml:5: open bad/green.png: file does not exist`,
+		`data.xml:6: bohm: tile bad/small.png: image is 1x1, tileset size is 2`,
+	)
+	checkProblems(t, problems, want)
+
+	// with the images fixed, the tiles without rules are reported. The
+	// rules of blue are all for its turned orientation.
+	fsys["bad/green.png"] = fsys["bad/red.png"]
+	fsys["bad/small.png"] = fsys["bad/red.png"]
+	if problems, err = ValidateTileset(fsys, "bad"); err != nil {
+		t.Fatal(err)
+	}
+
+	want = append(rules[:len(rules):len(rules)],
+		`data.xml:4: tile "blue" has no neighbour on its right`,
+		`data.xml:4: tile "blue" has no neighbour on its left`,
+	)
+	want = append(want, sideProblems(5, "green")...)
+	want = append(want, sideProblems(6, "small")...)
+	checkProblems(t, problems, want)
+
+	// the model itself refuses unknown names instead of using tile 0.
+	if _, err := NewTiledFS(fsys, "bad", TiledOptions{Width: 2, Height: 2}); err == nil ||
+		!strings.Contains(err.Error(), "data.xml:10") {
+		t.Errorf("NewTiledFS error = %v, want unknown tile at line 10", err)
+	}
+
+	// it accepts an orientation its tile does not have, but warns.
+	fsys["bad/data.xml"] = &fstest.MapFile{Data: []byte(strings.Replace(string(fsys["bad/data.xml"].Data), "bleu", "blue", 1))}
+	var warnings []string
+	_, err = NewTiledFS(fsys, "bad", TiledOptions{
+		Width:  2,
+		Height: 2,
+		Warnf: func(format string, v ...interface{}) {
+			warnings = append(warnings, fmt.Sprintf(format, v...))
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `bohm: data.xml:11: orientation 2 of tile "blue" is out of range, it has 2`; len(warnings) != 1 || warnings[0] != want {
+		t.Errorf("warnings %q, want %q", warnings, want)
+	}
+}
+
+// checkProblems compares problems with want, in any order.
+func checkProblems(t *testing.T, problems []TilesetProblem, want []string) {
+	t.Helper()
+
+	var got []string
+	for _, p := range problems {
+		got = append(got, p.String())
+	}
+	want = append([]string(nil), want...)
+	sort.Strings(got)
+	sort.Strings(want)
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("problems:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func sideProblems(line int, name string) []string {
+	var problems []string
+	for _, side := range directionNames {
+		problems = append(problems, (TilesetProblem{line, "tile \"" + name + "\" has no neighbour on its " + side}).String())
+	}
+	return problems
+}
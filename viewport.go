@@ -0,0 +1,89 @@
+package bohm
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// viewport maps a model's output onto dst for ModelDep.GraphicsInto.
+type viewport struct {
+	dst  draw.Image
+	rgba *image.RGBA // dst, if it is one
+
+	// r is clipped to the output and to what fits in dst; anchor is the
+	// output pixel drawn at the top left of dst.
+	r      image.Rectangle
+	anchor image.Point
+	scale  int
+}
+
+func newViewport(dst draw.Image, r, bounds image.Rectangle, scale int) (*viewport, error) {
+	if scale < 1 {
+		return nil, fmt.Errorf("bohm: scale %d, want at least 1", scale)
+	}
+
+	db := dst.Bounds()
+	fits := image.Rect(0, 0, (db.Dx()+scale-1)/scale, (db.Dy()+scale-1)/scale).Add(r.Min)
+
+	v := &viewport{
+		dst:    dst,
+		r:      r.Intersect(bounds).Intersect(fits),
+		anchor: r.Min,
+		scale:  scale,
+	}
+	v.rgba, _ = dst.(*image.RGBA)
+	return v, nil
+}
+
+// block returns the pixels of dst covered by output pixel (x, y).
+func (v *viewport) block(x, y int) image.Rectangle {
+	p := image.Pt(x, y).Sub(v.anchor).Mul(v.scale).Add(v.dst.Bounds().Min)
+	return image.Rectangle{p, p.Add(image.Pt(v.scale, v.scale))}.Intersect(v.dst.Bounds())
+}
+
+// set draws output pixel (x, y) in colour c.
+func (v *viewport) set(x, y int, c color.RGBA) {
+	b := v.block(x, y)
+	if v.rgba == nil {
+		for dy := b.Min.Y; dy < b.Max.Y; dy++ {
+			for dx := b.Min.X; dx < b.Max.X; dx++ {
+				v.dst.Set(dx, dy, c)
+			}
+		}
+		return
+	}
+
+	for dy := b.Min.Y; dy < b.Max.Y; dy++ {
+		line := v.rgba.Pix[v.rgba.PixOffset(b.Min.X, dy):]
+		for i := 0; i < 4*b.Dx(); i += 4 {
+			line[i], line[i+1], line[i+2], line[i+3] = c.R, c.G, c.B, c.A
+		}
+	}
+}
+
+// setPix draws the output pixels of rect from pix, which holds them in
+// RGBA order with the given stride.
+func (v *viewport) setPix(rect image.Rectangle, pix []byte, stride int) {
+	clip := rect.Intersect(v.r)
+	at := func(x, y int) []byte {
+		return pix[(y-rect.Min.Y)*stride+4*(x-rect.Min.X):]
+	}
+
+	// unmagnified pixels are copied a row at a time.
+	if v.rgba != nil && v.scale == 1 {
+		for y := clip.Min.Y; y < clip.Max.Y; y++ {
+			b := v.block(clip.Min.X, y)
+			copy(v.rgba.Pix[v.rgba.PixOffset(b.Min.X, b.Min.Y):], at(clip.Min.X, y)[:4*clip.Dx()])
+		}
+		return
+	}
+
+	for y := clip.Min.Y; y < clip.Max.Y; y++ {
+		for x := clip.Min.X; x < clip.Max.X; x++ {
+			p := at(x, y)
+			v.set(x, y, color.RGBA{p[0], p[1], p[2], p[3]})
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package bohm
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+
+	"vallon.me/bohm/config"
+)
+
+// anyImage hides an *image.RGBA, so that it is drawn into through Set.
+type anyImage struct {
+	*image.RGBA
+}
+
+// checkGraphicsInto draws viewports of m into dirty images and compares
+// them with its full rendering.
+func checkGraphicsInto(t *testing.T, m interface {
+	Graphics() (image.Image, error)
+	GraphicsInto(dst draw.Image, r image.Rectangle, scale int) error
+}) {
+	full, err := m.Graphics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dirt := color.RGBA{0xff, 0, 0xff, 0xff}
+
+	for _, tc := range []struct {
+		r, dst image.Rectangle
+		scale  int
+		hide   bool
+	}{
+		{full.Bounds(), full.Bounds(), 1, false},
+		{image.Rect(3, 2, 11, 9), image.Rect(5, 5, 25, 20), 3, false},
+		{image.Rect(-2, -1, 4, 30), image.Rect(0, 0, 17, 40), 2, false},
+		{image.Rect(1, 1, 30, 30), image.Rect(-4, 2, 9, 9), 1, false},
+		{image.Rect(3, 2, 11, 9), image.Rect(5, 5, 25, 20), 3, true},
+	} {
+		rgba := image.NewRGBA(tc.dst)
+		draw.Draw(rgba, tc.dst, image.NewUniform(dirt), image.Point{}, draw.Src)
+
+		var dst draw.Image = rgba
+		if tc.hide {
+			dst = anyImage{rgba}
+		}
+		if err := m.GraphicsInto(dst, tc.r, tc.scale); err != nil {
+			t.Fatal(err)
+		}
+
+		for y := tc.dst.Min.Y; y < tc.dst.Max.Y; y++ {
+			for x := tc.dst.Min.X; x < tc.dst.Max.X; x++ {
+				q := image.Pt(x, y).Sub(tc.dst.Min).Div(tc.scale).Add(tc.r.Min)
+
+				want := dirt
+				if q.In(tc.r) && q.In(full.Bounds()) {
+					want = color.RGBAModel.Convert(full.At(q.X, q.Y)).(color.RGBA)
+				}
+				if got := rgba.RGBAAt(x, y); got != want {
+					t.Fatalf("viewport %v at %v into %v: pixel (%d, %d) is %v, want %v", tc.r, tc.scale, tc.dst, x, y, got, want)
+				}
+			}
+		}
+	}
+
+	if err := m.GraphicsInto(image.NewRGBA(full.Bounds()), full.Bounds(), 0); err == nil {
+		t.Error("scale 0 accepted")
+	}
+}
+
+func TestTiledGraphicsInto(t *testing.T) {
+	ts := &config.TileSet{Size: 3}
+	ts.Tiles = []config.Tile{
+		{Name: "glass", Symmetry: "X", Weight: 1, Sockets: "a a a a", Images: []image.Image{solidTile(3, color.NRGBA{0x20, 0x40, 0x60, 0x08})}},
+		{Name: "stone", Symmetry: "X", Weight: 2, Sockets: "a a a a", Images: []image.Image{solidTile(3, color.NRGBA{0x60, 0x40, 0x20, 0xff})}},
+	}
+
+	for _, blend := range []Blend{BlendAverage, BlendLinear} {
+		tm, err := NewTiledFromSet(ts, TiledOptions{Width: 5, Height: 4, Black: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		tm.Blend = blend
+		tm.Run(testSeed, 6)
+
+		checkGraphicsInto(t, tm)
+	}
+}
+
+func TestOverlappingGraphicsInto(t *testing.T) {
+	samples := []OverlappingSample{
+		{Image: stripes(6, 3, testRed), Periodic: Periodicity{true, true}},
+	}
+
+	for _, blend := range []Blend{BlendAverage, BlendMostProbable} {
+		om, err := NewOverlappingImages(samples, OverlappingOptions{
+			N:        2,
+			Width:    12,
+			Height:   12,
+			Periodic: Periodicity{true, true},
+			Symmetry: SymIdentity,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		om.Blend = blend
+		om.Run(testSeed, 3)
+
+		checkGraphicsInto(t, om)
+	}
+}